@@ -0,0 +1,137 @@
+// Package acl provides per-tag access control driven by the metadata of authenticated clients.
+package acl
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// Action is the action that a client performs on a tag.
+type Action byte
+
+const (
+	Publish Action = 1 // Publish opens streams in the tag.
+	Observe Action = 2 // Observe observes streams in the tag.
+)
+
+// String returns a human-readable string which represents the action.
+func (a Action) String() string {
+	switch a {
+	case Publish:
+		return "publish"
+	case Observe:
+		return "observe"
+	default:
+		return "unknown"
+	}
+}
+
+// Rule allows the clients whose metadata matches Metadata to perform Action on the tags matching Tag.
+type Rule struct {
+	// Metadata is the key-value pairs that the client metadata must contain,
+	// An empty Metadata matches every client.
+	Metadata metadata.MD
+	// Action is the action allowed.
+	Action Action
+	// Tag is the tag pattern, The pattern syntax is the same as `path.Match`,
+	// for example, `sensors.*` matches `sensors.temperature`.
+	Tag string
+}
+
+// Match reports whether the rule allows the client with md to perform action on tag.
+func (r Rule) Match(md metadata.MD, action Action, tag string) bool {
+	if r.Action != action {
+		return false
+	}
+	for k, values := range r.Metadata {
+		for _, v := range values {
+			if !contains(md.Values(k), v) {
+				return false
+			}
+		}
+	}
+	ok, err := path.Match(r.Tag, tag)
+	return ok && err == nil
+}
+
+// ParseRule parses a rule from text in the form of `role=ingest may publish sensors.*`,
+// Multiple key-value pairs are separated by `,`, and `*` in place of them matches every client.
+func ParseRule(text string) (Rule, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 4 || fields[1] != "may" {
+		return Rule{}, fmt.Errorf("acl: invalid rule: %s", text)
+	}
+
+	rule := Rule{Metadata: metadata.MD{}, Tag: fields[3]}
+
+	if fields[0] != "*" {
+		for _, pair := range strings.Split(fields[0], ",") {
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok || k == "" {
+				return Rule{}, fmt.Errorf("acl: invalid metadata in rule: %s", text)
+			}
+			rule.Metadata.Add(k, v)
+		}
+	}
+
+	switch fields[2] {
+	case Publish.String():
+		rule.Action = Publish
+	case Observe.String():
+		rule.Action = Observe
+	default:
+		return Rule{}, fmt.Errorf("acl: invalid action in rule: %s", text)
+	}
+
+	if _, err := path.Match(rule.Tag, ""); err != nil {
+		return Rule{}, fmt.Errorf("acl: invalid tag pattern in rule: %s", text)
+	}
+
+	return rule, nil
+}
+
+// ACL authorizes clients with rules, It denies every action that no rule allows.
+type ACL struct {
+	rules []Rule
+}
+
+// New returns an ACL with the rules.
+func New(rules ...Rule) *ACL {
+	return &ACL{rules: rules}
+}
+
+// Parse returns an ACL with the rules parsed from lines, see `ParseRule` for the rule format.
+func Parse(lines ...string) (*ACL, error) {
+	rules := make([]Rule, 0, len(lines))
+	for _, line := range lines {
+		rule, err := ParseRule(line)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return New(rules...), nil
+}
+
+// Allow reports whether the client with md is allowed to perform action on tag.
+func (a *ACL) Allow(md metadata.MD, action Action, tag string) bool {
+	for _, rule := range a.rules {
+		if rule.Match(md, action, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether the client has the value, a client with multiple values of a key matches any of them.
+func contains(values []string, v string) bool {
+	for _, vv := range values {
+		if vv == v {
+			return true
+		}
+	}
+	return false
+}
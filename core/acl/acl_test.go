@@ -0,0 +1,48 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+func TestACL(t *testing.T) {
+	a, err := Parse(
+		"role=ingest may publish sensors.*",
+		"role=analytics,team=data may observe sensors.*",
+		"* may observe public",
+	)
+	assert.NoError(t, err)
+
+	ingest := metadata.MD{"role": {"ingest"}}
+	analytics := metadata.MD{"role": {"analytics"}, "team": {"data"}}
+
+	assert.True(t, a.Allow(ingest, Publish, "sensors.temperature"))
+	assert.False(t, a.Allow(ingest, Observe, "sensors.temperature"))
+	assert.False(t, a.Allow(ingest, Publish, "logs"))
+
+	assert.True(t, a.Allow(analytics, Observe, "sensors.temperature"))
+	assert.False(t, a.Allow(analytics, Publish, "sensors.temperature"))
+	assert.False(t, a.Allow(metadata.MD{"role": {"analytics"}}, Observe, "sensors.temperature"))
+
+	// a client with multiple roles matches any of them.
+	both := metadata.MD{"role": {"analytics", "ingest"}}
+	assert.True(t, a.Allow(both, Publish, "sensors.temperature"))
+
+	assert.True(t, a.Allow(nil, Observe, "public"))
+	assert.False(t, a.Allow(nil, Publish, "public"))
+}
+
+func TestParseRule(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"role=ingest publish sensors.*",
+		"role=ingest may write sensors.*",
+		"=ingest may publish sensors.*",
+		"role=ingest may publish [",
+	} {
+		_, err := ParseRule(text)
+		assert.Error(t, err, text)
+	}
+}
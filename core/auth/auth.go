@@ -2,16 +2,13 @@
 package auth
 
 import (
+	"crypto/tls"
 	"strings"
 
 	"github.com/woorui/ydesign/core/frame"
 	"github.com/woorui/ydesign/core/metadata"
 )
 
-var (
-	auths = make(map[string]Authentication)
-)
-
 // Authentication for server
 type Authentication interface {
 	// Authenticate authentication client's credential
@@ -20,15 +17,18 @@ type Authentication interface {
 	Name() string
 }
 
-// Register register authentication
+// ExpiresAtKey is the metadata key of the time when the credential expires, in unix seconds.
+// Authentications set it if their credentials expire, the server reauthenticates the client before it.
+const ExpiresAtKey = "auth_expires_at"
+
+// Register register authentication to the default registry.
 func Register(authentication Authentication) {
-	auths[authentication.Name()] = authentication
+	defaultRegistry.Register(authentication)
 }
 
-// GetAuth get authentication by name
+// GetAuth get authentication by name from the default registry.
 func GetAuth(name string) (Authentication, bool) {
-	auth, ok := auths[name]
-	return auth, ok
+	return defaultRegistry.Get(name)
 }
 
 // Credential client credential.
@@ -70,19 +70,9 @@ func (c *credential) Name() string {
 // Authenticate finds an authentication way in `auths` and authenticates the Object.
 //
 // If `auths` is nil or empty, It returns true, It think that authentication is not required.
+//
+// Deprecated: Use `Chain` or `Registry`, which never allow a client implicitly.
+// Authenticate is a `Chain` that allows anonymous clients if `auths` is empty.
 func Authenticate(auths map[string]Authentication, obj *frame.AuthenticationFrame) (metadata.MD, bool) {
-	if auths == nil || len(auths) <= 0 {
-		return nil, true
-	}
-
-	if obj == nil {
-		return nil, false
-	}
-
-	auth, ok := auths[obj.AuthName]
-	if !ok {
-		return nil, false
-	}
-
-	return auth.Authenticate(obj.AuthPayload)
+	return legacyChain(auths, nil, nil).authenticateLegacy(obj, tls.ConnectionState{}, nil)
 }
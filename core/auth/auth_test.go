@@ -0,0 +1,337 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+func TestTokenAuth(t *testing.T) {
+	a := NewTokenAuth(
+		Token{Token: "token-a", Metadata: metadata.MD{"role": {"ingest"}}},
+		Token{Token: "token-b"},
+	)
+
+	cred := NewCredential("token:token-a")
+	assert.Equal(t, a.Name(), cred.Name())
+
+	md, ok := a.Authenticate(cred.Payload())
+	assert.True(t, ok)
+	assert.Equal(t, metadata.MD{"role": {"ingest"}}, md)
+
+	md, ok = a.Authenticate("token-b")
+	assert.True(t, ok)
+	assert.Equal(t, metadata.MD{}, md)
+
+	_, ok = a.Authenticate("token-c")
+	assert.False(t, ok)
+}
+
+func TestHMACAuth(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+
+	a := NewHMACAuth(map[string]HMACKey{
+		"device-1": {Secret: secret, Metadata: metadata.MD{"device": {"1"}}},
+	}, time.Minute)
+	a.now = func() time.Time { return now }
+
+	token, err := SignHMACToken("device-1", secret, now.Add(-30*time.Second))
+	assert.NoError(t, err)
+
+	cred := NewCredential("hmac:" + token)
+	assert.Equal(t, a.Name(), cred.Name())
+
+	md, ok := a.Authenticate(cred.Payload())
+	assert.True(t, ok)
+	assert.Equal(t, metadata.MD{"device": {"1"}}, md)
+
+	// replay.
+	_, ok = a.Authenticate(cred.Payload())
+	assert.False(t, ok)
+
+	// out of clock skew bounds.
+	token, _ = SignHMACToken("device-1", secret, now.Add(-2*time.Minute))
+	_, ok = a.Authenticate(token)
+	assert.False(t, ok)
+
+	// wrong secret.
+	token, _ = SignHMACToken("device-1", []byte("wrong"), now)
+	_, ok = a.Authenticate(token)
+	assert.False(t, ok)
+
+	// unknown key.
+	token, _ = SignHMACToken("device-2", secret, now)
+	_, ok = a.Authenticate(token)
+	assert.False(t, ok)
+}
+
+func TestLegacyAuthenticate(t *testing.T) {
+	auths := map[string]Authentication{"token": NewTokenAuth(Token{Token: "t", Metadata: metadata.MD{"role": {"ingest"}}})}
+	obj := &frame.AuthenticationFrame{AuthName: "token", AuthPayload: "t"}
+
+	// no authentication means authentication is not required.
+	md, ok := Authenticate(nil, nil)
+	assert.True(t, ok)
+	assert.Empty(t, md)
+	_, ok = AuthenticateChallenge(nil, nil, obj, nil)
+	assert.True(t, ok)
+
+	md, ok = Authenticate(auths, obj)
+	assert.True(t, ok)
+	assert.Equal(t, metadata.MD{"role": {"ingest"}}, md)
+
+	// the client is denied if no authentication handles the credential.
+	for _, obj := range []*frame.AuthenticationFrame{
+		nil,
+		{AuthName: "none"},
+		{AuthName: "jwt", AuthPayload: "t"},
+		{AuthName: "token", AuthPayload: "x"},
+	} {
+		_, ok := Authenticate(auths, obj)
+		assert.False(t, ok, obj)
+		_, ok = AuthenticateChallenge(auths, nil, obj, nil)
+		assert.False(t, ok, obj)
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	hsKey := []byte("secret")
+	rsKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	esKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	a := NewJWTAuth(&JWTOption{
+		Keys: map[string]any{
+			"hs": hsKey,
+			"rs": &rsKey.PublicKey,
+			"es": &esKey.PublicKey,
+		},
+		Issuer:   "issuer",
+		Audience: "broker",
+		Leeway:   time.Second,
+		Claims:   map[string]string{"sub": "user", "admin": "admin"},
+	})
+	a.now = func() time.Time { return now }
+
+	claims := map[string]any{
+		"sub":   "alice",
+		"admin": true,
+		"iss":   "issuer",
+		"aud":   []string{"broker"},
+		"exp":   now.Add(time.Minute).Unix(),
+		"nbf":   now.Unix(),
+	}
+
+	for _, kid := range []string{"hs", "rs", "es"} {
+		var key any
+		switch kid {
+		case "hs":
+			key = hsKey
+		case "rs":
+			key = rsKey
+		case "es":
+			key = esKey
+		}
+		cred := NewCredential("jwt:" + signJWT(t, kid, key, claims))
+		assert.Equal(t, a.Name(), cred.Name())
+
+		md, ok := a.Authenticate(cred.Payload())
+		assert.True(t, ok, kid)
+		assert.Equal(t, metadata.MD{
+			"user":       {"alice"},
+			"admin":      {"true"},
+			ExpiresAtKey: {strconv.FormatInt(now.Add(time.Minute).Unix(), 10)},
+		}, md, kid)
+	}
+
+	for name, modify := range map[string]func(map[string]any){
+		"expired":        func(c map[string]any) { c["exp"] = now.Add(-time.Minute).Unix() },
+		"not valid yet":  func(c map[string]any) { c["nbf"] = now.Add(time.Minute).Unix() },
+		"wrong issuer":   func(c map[string]any) { c["iss"] = "other" },
+		"wrong audience": func(c map[string]any) { c["aud"] = "other" },
+		"no expiry":      func(c map[string]any) { delete(c, "exp") },
+	} {
+		c := make(map[string]any, len(claims))
+		for k, v := range claims {
+			c[k] = v
+		}
+		modify(c)
+		_, ok := a.Authenticate(signJWT(t, "hs", hsKey, c))
+		assert.False(t, ok, name)
+	}
+
+	// wrong key.
+	_, ok := a.Authenticate(signJWT(t, "hs", []byte("wrong"), claims))
+	assert.False(t, ok)
+
+	// unknown key id.
+	_, ok = a.Authenticate(signJWT(t, "unknown", hsKey, claims))
+	assert.False(t, ok)
+
+	// the token without expiry is accepted only if it is allowed explicitly.
+	noExpiry := NewJWTAuth(&JWTOption{Keys: map[string]any{"hs": hsKey}, AllowNoExpiry: true})
+	md, ok := noExpiry.Authenticate(signJWT(t, "hs", hsKey, map[string]any{"sub": "alice"}))
+	assert.True(t, ok)
+	assert.Empty(t, md.Values(ExpiresAtKey))
+}
+
+func TestLoadJWKS(t *testing.T) {
+	rsKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	esKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks := map[string]any{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rs", "n": encode(rsKey.N.Bytes()), "e": encode(big.NewInt(int64(rsKey.E)).Bytes())},
+			{"kty": "EC", "kid": "es", "crv": "P-256", "x": encode(esKey.X.FillBytes(make([]byte, 32))), "y": encode(esKey.Y.FillBytes(make([]byte, 32)))},
+		},
+	}
+	data, err := json.Marshal(jwks)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+
+	keys, err := LoadJWKS(path)
+	assert.NoError(t, err)
+
+	a := NewJWTAuth(&JWTOption{Keys: keys})
+	claims := map[string]any{"sub": "alice", "exp": time.Now().Add(time.Minute).Unix()}
+
+	_, ok := a.Authenticate(signJWT(t, "rs", rsKey, claims))
+	assert.True(t, ok)
+	_, ok = a.Authenticate(signJWT(t, "es", esKey, claims))
+	assert.True(t, ok)
+}
+
+func signJWT(t *testing.T, kid string, key any, claims map[string]any) string {
+	var alg string
+	switch key.(type) {
+	case []byte:
+		alg = "HS256"
+	case *rsa.PrivateKey:
+		alg = "RS256"
+	case *ecdsa.PrivateKey:
+		alg = "ES256"
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+		signature = sig
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		assert.NoError(t, err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestSCRAMAuth(t *testing.T) {
+	a := NewSCRAMAuth(map[string]SCRAMUser{
+		"alice": NewSCRAMUser("password", []byte("salt"), 4096, metadata.MD{"user": {"alice"}}),
+	})
+	challengeAuths := map[string]ChallengeAuthentication{a.Name(): a}
+
+	authenticate := func(user, password string) (metadata.MD, bool) {
+		cred, err := NewSCRAMCredential(user, password)
+		assert.NoError(t, err)
+
+		obj := &frame.AuthenticationFrame{AuthName: cred.Name(), AuthPayload: cred.Payload()}
+		return AuthenticateChallenge(nil, challengeAuths, obj, cred.Respond)
+	}
+
+	md, ok := authenticate("alice", "password")
+	assert.True(t, ok)
+	assert.Equal(t, metadata.MD{"user": {"alice"}}, md)
+
+	_, ok = authenticate("alice", "wrong")
+	assert.False(t, ok)
+
+	_, ok = authenticate("bob", "password")
+	assert.False(t, ok)
+
+	// the unknown user is challenged like a known user, with the same fake salt every time.
+	serverFirst := func(user string) map[string]string {
+		cred, err := NewSCRAMCredential(user, "password")
+		assert.NoError(t, err)
+		var attrs map[string]string
+		a.AuthenticateChallenge(cred.Payload(), func(challenge string) (string, error) {
+			attrs = parseSCRAM(challenge)
+			return cred.Respond(challenge)
+		})
+		return attrs
+	}
+	bob := serverFirst("bob")
+	assert.NotEmpty(t, bob["s"])
+	assert.Equal(t, bob["s"], serverFirst("bob")["s"])
+	assert.NotEqual(t, bob["s"], serverFirst("carol")["s"])
+	assert.Equal(t, serverFirst("alice")["i"], bob["i"])
+
+	// every exchange has a fresh client nonce.
+	cred, err := NewSCRAMCredential("alice", "password")
+	assert.NoError(t, err)
+	assert.NotEqual(t, parseSCRAM(cred.Payload())["r"], parseSCRAM(cred.Payload())["r"])
+
+	// replaying the response of another exchange.
+	cred, _ = NewSCRAMCredential("alice", "password")
+	var replayed string
+	_, ok = a.AuthenticateChallenge(cred.Payload(), func(challenge string) (string, error) {
+		replayed, _ = cred.Respond(challenge)
+		return replayed, nil
+	})
+	assert.True(t, ok)
+	_, ok = a.AuthenticateChallenge(cred.Payload(), func(string) (string, error) { return replayed, nil })
+	assert.False(t, ok)
+
+	// the client refuses the server weakening the iterations.
+	weak := NewSCRAMAuth(map[string]SCRAMUser{
+		"alice": NewSCRAMUser("password", []byte("salt"), MinSCRAMIterations-1, nil),
+	})
+	cred, _ = NewSCRAMCredential("alice", "password")
+	var respondErr error
+	_, ok = weak.AuthenticateChallenge(cred.Payload(), func(challenge string) (string, error) {
+		response, err := cred.Respond(challenge)
+		respondErr = err
+		return response, err
+	})
+	assert.False(t, ok)
+	assert.ErrorContains(t, respondErr, "iterations")
+
+	_, _, err = LoadConfig([]byte(`{"scram": {"alice": {"salt": "c2FsdA==", "iterations": 1, "stored_key": "AA=="}}}`))
+	assert.ErrorContains(t, err, "iterations")
+}
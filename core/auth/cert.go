@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"strings"
+
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// CertAuth authenticates clients with the verified TLS peer certificate of the connection.
+// The server's tls.Config must verify the client certificates, for example, setting ClientAuth
+// to tls.RequireAndVerifyClientCert, otherwise the certificates will not be trusted.
+//
+// The returned metadata contains:
+//   - `cert_subject`: the subject of the certificate.
+//   - `cert_cn`: the common name of the subject.
+//   - `cert_dns`: the DNS SANs, one value per SAN.
+//   - `cert_email`: the email SANs, one value per SAN.
+//   - `cert_uri`: the URI SANs, one value per SAN.
+//   - `spiffe_id`: the SPIFFE ID, it is the first URI SAN with `spiffe` scheme.
+//
+// The keys `spiffe_id` and `cert_*` are reserved for the certificate, the credential authenticated
+// with the certificate cannot set or override them.
+type CertAuth struct {
+	// TrustDomain requires the certificate to have a SPIFFE ID in the trust domain if it is not empty.
+	TrustDomain string
+}
+
+// AuthenticateCert authenticates the client with the verified peer certificate in state.
+func (a *CertAuth) AuthenticateCert(state tls.ConnectionState) (metadata.MD, bool) {
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil, false
+	}
+	cert := state.PeerCertificates[0]
+
+	md := metadata.MD{
+		"cert_subject": {cert.Subject.String()},
+		"cert_cn":      {cert.Subject.CommonName},
+	}
+	if len(cert.DNSNames) > 0 {
+		md["cert_dns"] = append([]string(nil), cert.DNSNames...)
+	}
+	if len(cert.EmailAddresses) > 0 {
+		md["cert_email"] = append([]string(nil), cert.EmailAddresses...)
+	}
+	for _, u := range cert.URIs {
+		md.Add("cert_uri", u.String())
+	}
+
+	spiffeID := spiffeURI(cert)
+	if spiffeID != nil {
+		md.Set("spiffe_id", spiffeID.String())
+	}
+	if a.TrustDomain != "" && (spiffeID == nil || spiffeID.Host != a.TrustDomain) {
+		return nil, false
+	}
+
+	return md, true
+}
+
+// isCertKey reports whether the metadata key is reserved for the peer certificate.
+func isCertKey(key string) bool {
+	return key == "spiffe_id" || strings.HasPrefix(key, "cert_")
+}
+
+// mergeCertMetadata merges credMD into certMD and returns certMD, the reserved keys of credMD are dropped,
+// so the identity derived from the certificate cannot be spoofed by the credential.
+func mergeCertMetadata(certMD, credMD metadata.MD) metadata.MD {
+	for k, v := range credMD {
+		if isCertKey(k) {
+			continue
+		}
+		certMD[k] = v
+	}
+	return certMD
+}
+
+func spiffeURI(cert *x509.Certificate) *url.URL {
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			return u
+		}
+	}
+	return nil
+}
+
+// AuthenticateConn authenticates the client with both the peer certificate and the credential.
+//
+// If cert is nil, It is the same as `Authenticate`.
+// If cert is not nil, the peer certificate must be authenticated. If the client presents no credential,
+// the certificate is an alternative to the credential and the metadata of the certificate is returned,
+// otherwise the credential must be authenticated in addition and the metadata of both are merged.
+//
+// Deprecated: Use `Chain.WithCertAuth` or `Registry.SetCertAuth`, which never allow a credential implicitly.
+// AuthenticateConn is a `Chain` that allows anonymous clients if `auths` is empty.
+func AuthenticateConn(auths map[string]Authentication, cert *CertAuth, obj *frame.AuthenticationFrame, state tls.ConnectionState) (metadata.MD, bool) {
+	return legacyChain(auths, nil, cert).authenticateLegacy(obj, state, nil)
+}
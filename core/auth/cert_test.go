@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+func TestCertAuth(t *testing.T) {
+	spiffeID, _ := url.Parse("spiffe://example.org/device/1")
+	cert := newTestCert(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "device-1"},
+		DNSNames: []string{"device-1.example.org"},
+		URIs:     []*url.URL{spiffeID},
+	})
+	state := tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+
+	md, ok := (&CertAuth{TrustDomain: "example.org"}).AuthenticateCert(state)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"device-1"}, md["cert_cn"])
+	assert.Equal(t, []string{"CN=device-1"}, md["cert_subject"])
+	assert.Equal(t, []string{"device-1.example.org"}, md["cert_dns"])
+	assert.Equal(t, []string{"spiffe://example.org/device/1"}, md["spiffe_id"])
+
+	_, ok = (&CertAuth{TrustDomain: "other.org"}).AuthenticateCert(state)
+	assert.False(t, ok)
+
+	// the certificate is not verified.
+	_, ok = (&CertAuth{}).AuthenticateCert(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	assert.False(t, ok)
+
+	// certificate as an alternative to credential.
+	auths := map[string]Authentication{"token": NewTokenAuth(Token{Token: "t", Metadata: metadata.MD{"role": {"ingest"}}})}
+	md, ok = AuthenticateConn(auths, &CertAuth{}, &frame.AuthenticationFrame{AuthName: "none"}, state)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"device-1"}, md["cert_cn"])
+
+	// certificate in addition to credential.
+	md, ok = AuthenticateConn(auths, &CertAuth{}, &frame.AuthenticationFrame{AuthName: "token", AuthPayload: "t"}, state)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"device-1"}, md["cert_cn"])
+	assert.Equal(t, []string{"ingest"}, md["role"])
+
+	_, ok = AuthenticateConn(auths, &CertAuth{}, &frame.AuthenticationFrame{AuthName: "token", AuthPayload: "x"}, state)
+	assert.False(t, ok)
+
+	// the credential cannot set or override the keys of the certificate.
+	spoofing := NewTokenAuth(Token{Token: "t", Metadata: metadata.MD{
+		"role":      {"ingest"},
+		"cert_cn":   {"admin"},
+		"cert_uri":  {"spiffe://example.org/admin"},
+		"spiffe_id": {"spiffe://example.org/admin"},
+	}})
+	obj := &frame.AuthenticationFrame{AuthName: "token", AuthPayload: "t"}
+	want := metadata.MD{
+		"role":         {"ingest"},
+		"cert_cn":      {"device-1"},
+		"cert_subject": {"CN=device-1"},
+		"cert_dns":     {"device-1.example.org"},
+		"cert_uri":     {"spiffe://example.org/device/1"},
+		"spiffe_id":    {"spiffe://example.org/device/1"},
+	}
+
+	md, ok = AuthenticateConn(map[string]Authentication{"token": spoofing}, &CertAuth{}, obj, state)
+	assert.True(t, ok)
+	assert.Equal(t, want, md)
+
+	chain, err := NewChain(spoofing)
+	assert.NoError(t, err)
+	md, err = chain.WithCertAuth(&CertAuth{}).Authenticate(obj, state, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, want, md)
+}
+
+func newTestCert(t *testing.T, template *x509.Certificate) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(1)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	return cert
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// Matcher is implemented by the authentications that share a credential name with others,
+// Match reports whether the payload is handled by the authentication, `Chain` falls through
+// to the next authentication if it is not.
+type Matcher interface {
+	Match(payload string) bool
+}
+
+// DeniedError is returned when the client is denied, Reason tells why.
+type DeniedError struct {
+	Reason string
+}
+
+// Error returns a string that represents the DeniedError error for the implementation of the error interface.
+func (e *DeniedError) Error() string { return "auth: denied: " + e.Reason }
+
+// Chain authenticates clients with the authentications in order,
+// unlike `Authenticate`, it never allows a client implicitly.
+//
+// The authentications whose name is the credential name handle the credential in order,
+// the authentication that implements `Matcher` and does not match the payload is skipped.
+// The first authentication that handles the credential decides the result.
+// If no authentication handles the credential, the client is anonymous, It is allowed with
+// the anonymous metadata if anonymous clients are allowed, otherwise it is denied.
+//
+// Chain is immutable, use `Registry` to change the authentications at runtime.
+type Chain struct {
+	links     []link
+	anonymous metadata.MD
+	cert      *CertAuth
+}
+
+type link struct {
+	name          string
+	auth          Authentication
+	challengeAuth ChallengeAuthentication
+}
+
+// NewChain returns a Chain with the authentications, every authentication
+// must be an `Authentication` or a `ChallengeAuthentication`.
+func NewChain(authentications ...any) (*Chain, error) {
+	c := &Chain{}
+	for _, a := range authentications {
+		switch aa := a.(type) {
+		case Authentication:
+			c.links = append(c.links, link{name: aa.Name(), auth: aa})
+		case ChallengeAuthentication:
+			c.links = append(c.links, link{name: aa.Name(), challengeAuth: aa})
+		default:
+			return nil, fmt.Errorf("auth: %T is not an authentication", a)
+		}
+	}
+	return c, nil
+}
+
+// WithAnonymous returns a copy of the chain that allows anonymous clients with md,
+// md should be restricted, for example `role=anonymous`. A nil md denies anonymous clients.
+func (c *Chain) WithAnonymous(md metadata.MD) *Chain {
+	c2 := *c
+	c2.anonymous = md.Clone()
+	return &c2
+}
+
+// WithCertAuth returns a copy of the chain that requires the peer certificate to be authenticated
+// by cert, see `AuthenticateConn` for how the certificate works with credential. A nil cert disables it.
+func (c *Chain) WithCertAuth(cert *CertAuth) *Chain {
+	c2 := *c
+	c2.cert = cert
+	return &c2
+}
+
+// Authenticate authenticates the Object and the TLS state, The challenge authentication challenges
+// the client with challenge. It returns `*DeniedError` if the client is denied.
+func (c *Chain) Authenticate(obj *frame.AuthenticationFrame, state tls.ConnectionState, challenge ChallengeFunc) (metadata.MD, error) {
+	var certMD metadata.MD
+	if c.cert != nil {
+		md, ok := c.cert.AuthenticateCert(state)
+		if !ok {
+			return nil, &DeniedError{Reason: "peer certificate is not authenticated"}
+		}
+		if obj == nil || obj.AuthName == "none" {
+			return md, nil
+		}
+		certMD = md
+	}
+
+	md, err := c.authenticateCredential(obj, challenge)
+	if err != nil {
+		return nil, err
+	}
+	if certMD != nil {
+		return mergeCertMetadata(certMD, md), nil
+	}
+
+	return md, nil
+}
+
+// legacyChain returns the chain of the deprecated package-level functions, the authentications are named
+// by the keys of the maps and the challenge authentications take precedence. It allows anonymous clients
+// with empty metadata if there is no authentication, as the functions think authentication is not required.
+func legacyChain(auths map[string]Authentication, challengeAuths map[string]ChallengeAuthentication, cert *CertAuth) *Chain {
+	c := &Chain{cert: cert}
+	for name, a := range challengeAuths {
+		c.links = append(c.links, link{name: name, challengeAuth: a})
+	}
+	for name, a := range auths {
+		c.links = append(c.links, link{name: name, auth: a})
+	}
+	if len(c.links) == 0 {
+		c.anonymous = metadata.MD{}
+	}
+	return c
+}
+
+// authenticateLegacy is Authenticate that reports the result as the deprecated package-level functions.
+func (c *Chain) authenticateLegacy(obj *frame.AuthenticationFrame, state tls.ConnectionState, challenge ChallengeFunc) (metadata.MD, bool) {
+	md, err := c.Authenticate(obj, state, challenge)
+	if err != nil {
+		return nil, false
+	}
+	return md, true
+}
+
+func (c *Chain) authenticateCredential(obj *frame.AuthenticationFrame, challenge ChallengeFunc) (metadata.MD, error) {
+	name, payload := "none", ""
+	if obj != nil {
+		name, payload = obj.AuthName, obj.AuthPayload
+	}
+
+	for _, l := range c.links {
+		if l.name != name {
+			continue
+		}
+
+		var (
+			md metadata.MD
+			ok bool
+		)
+		if l.auth != nil {
+			if m, isMatcher := l.auth.(Matcher); isMatcher && !m.Match(payload) {
+				continue
+			}
+			md, ok = l.auth.Authenticate(payload)
+		} else {
+			if m, isMatcher := l.challengeAuth.(Matcher); isMatcher && !m.Match(payload) {
+				continue
+			}
+			md, ok = l.challengeAuth.AuthenticateChallenge(payload, challenge)
+		}
+
+		if !ok {
+			return nil, &DeniedError{Reason: fmt.Sprintf("credential is rejected by %s authentication", name)}
+		}
+		return principal(md), nil
+	}
+
+	if c.anonymous != nil {
+		return c.anonymous.Clone(), nil
+	}
+	if name == "none" {
+		return nil, &DeniedError{Reason: "credential is required"}
+	}
+	return nil, &DeniedError{Reason: fmt.Sprintf("no authentication handles credential %s", name)}
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"crypto/tls"
+
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// ChallengeFunc sends the challenge to the client and returns the response of the client.
+type ChallengeFunc func(challenge string) (response string, err error)
+
+// ChallengeAuthentication is the multi-step counterpart of Authentication,
+// It can challenge the client multiple times during the authentication, so the secrets never cross the wire.
+type ChallengeAuthentication interface {
+	// AuthenticateChallenge authenticates the first payload from client, It can call challenge
+	// to send challenges to the client and the state of the exchange is kept in the call.
+	AuthenticateChallenge(payload string, challenge ChallengeFunc) (metadata.MD, bool)
+	// Name authentication name
+	Name() string
+}
+
+// ChallengeCredential is the client credential that answers the challenges from server.
+type ChallengeCredential interface {
+	Credential
+	// Respond returns the response to the challenge.
+	Respond(challenge string) (string, error)
+}
+
+// RegisterChallenge register challenge authentication to the default registry.
+func RegisterChallenge(authentication ChallengeAuthentication) {
+	defaultRegistry.RegisterChallenge(authentication)
+}
+
+// GetChallengeAuth get challenge authentication by name from the default registry.
+func GetChallengeAuth(name string) (ChallengeAuthentication, bool) {
+	return defaultRegistry.GetChallenge(name)
+}
+
+// AuthenticateChallenge finds an authentication way in `auths` or `challengeAuths` and authenticates the Object,
+// The challenge authentication challenges the client with challenge.
+//
+// If both `auths` and `challengeAuths` are empty, It returns true, It think that authentication is not required.
+//
+// Deprecated: Use `Chain` or `Registry`, which never allow a client implicitly.
+// AuthenticateChallenge is a `Chain` that allows anonymous clients if both `auths` and `challengeAuths` are empty.
+func AuthenticateChallenge(
+	auths map[string]Authentication, challengeAuths map[string]ChallengeAuthentication,
+	obj *frame.AuthenticationFrame, challenge ChallengeFunc) (metadata.MD, bool) {
+	return legacyChain(auths, challengeAuths, nil).authenticateLegacy(obj, tls.ConnectionState{}, challenge)
+}
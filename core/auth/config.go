@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// Config is the file format of the built-in authentications, It is loaded by `LoadConfig`.
+//
+//	{
+//	  "tokens": [{"token": "secret", "metadata": {"role": "ingest"}}],
+//	  "hmac": {"max_skew": "1m", "keys": {"device-1": {"secret": "secret", "metadata": {"device": "1"}}}},
+//	  "scram": {"alice": {"salt": "c2FsdA==", "iterations": 4096, "stored_key": "...", "metadata": {}}}
+//	}
+type Config struct {
+	Tokens []struct {
+		Token    string      `json:"token"`
+		Metadata metadata.MD `json:"metadata"`
+	} `json:"tokens"`
+	HMAC *struct {
+		MaxSkew string `json:"max_skew"`
+		Keys    map[string]struct {
+			Secret   string      `json:"secret"`
+			Metadata metadata.MD `json:"metadata"`
+		} `json:"keys"`
+	} `json:"hmac"`
+	SCRAM map[string]struct {
+		Salt       []byte      `json:"salt"`
+		Iterations int         `json:"iterations"`
+		StoredKey  []byte      `json:"stored_key"`
+		Metadata   metadata.MD `json:"metadata"`
+	} `json:"scram"`
+}
+
+// LoadConfig loads the built-in authentications from the JSON encoded `Config`,
+// It can be used as the LoadFunc of `Registry.WatchFile`.
+func LoadConfig(data []byte) ([]Authentication, []ChallengeAuthentication, error) {
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		auths          []Authentication
+		challengeAuths []ChallengeAuthentication
+	)
+
+	if len(config.Tokens) > 0 {
+		tokens := make([]Token, len(config.Tokens))
+		for i, t := range config.Tokens {
+			tokens[i] = Token{Token: t.Token, Metadata: t.Metadata}
+		}
+		auths = append(auths, NewTokenAuth(tokens...))
+	}
+
+	if config.HMAC != nil {
+		maxSkew, err := time.ParseDuration(config.HMAC.MaxSkew)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys := make(map[string]HMACKey, len(config.HMAC.Keys))
+		for id, k := range config.HMAC.Keys {
+			keys[id] = HMACKey{Secret: []byte(k.Secret), Metadata: k.Metadata}
+		}
+		auths = append(auths, NewHMACAuth(keys, maxSkew))
+	}
+
+	if len(config.SCRAM) > 0 {
+		users := make(map[string]SCRAMUser, len(config.SCRAM))
+		for name, u := range config.SCRAM {
+			if u.Iterations < MinSCRAMIterations {
+				return nil, nil, fmt.Errorf("auth: scram iterations of %s is less than %d", name, MinSCRAMIterations)
+			}
+			users[name] = SCRAMUser{Salt: u.Salt, Iterations: u.Iterations, StoredKey: u.StoredKey, Metadata: u.Metadata}
+		}
+		challengeAuths = append(challengeAuths, NewSCRAMAuth(users))
+	}
+
+	return auths, challengeAuths, nil
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// HMACKey is a key to sign HMAC tokens and the metadata describing its principal.
+type HMACKey struct {
+	// Secret is the secret of the key.
+	Secret []byte
+	// Metadata describes the principal of the key, It is returned once authenticated.
+	Metadata metadata.MD
+}
+
+// HMACAuth authenticates clients with HMAC-signed timestamped tokens,
+// The credential of client is in the form of `hmac:<key id>:<unix seconds>:<nonce>:<signature>`,
+// `SignHMACToken` creates the token.
+//
+// A token is accepted only if its timestamp is within the clock skew bounds,
+// and it can be used only once during that window. The used tokens are kept when
+// the HMACAuth is replaced by another one in a `Registry`, for example, when the config is reloaded.
+type HMACAuth struct {
+	keys    map[string]HMACKey
+	maxSkew time.Duration
+	now     func() time.Time
+	replay  *replayCache
+}
+
+// replayCache stores the tokens used in the replay window.
+type replayCache struct {
+	mu sync.Mutex
+	// seen is keyed by the key id and the nonce, the value is the time when the token expires.
+	seen map[string]time.Time
+}
+
+var _ Authentication = (*HMACAuth)(nil)
+
+// NewHMACAuth returns an HMACAuth with the keys, the keys of the map are key ids.
+// maxSkew is the maximum clock skew between the client and the server.
+func NewHMACAuth(keys map[string]HMACKey, maxSkew time.Duration) *HMACAuth {
+	return &HMACAuth{
+		keys:    keys,
+		maxSkew: maxSkew,
+		now:     time.Now,
+		replay:  &replayCache{seen: make(map[string]time.Time)},
+	}
+}
+
+// inherit shares the used tokens of old if it is an HMACAuth, so the tokens used before
+// the replacement cannot be replayed.
+func (a *HMACAuth) inherit(old any) {
+	if o, ok := old.(*HMACAuth); ok {
+		a.replay = o.replay
+	}
+}
+
+// Name returns the name of HMACAuth.
+func (a *HMACAuth) Name() string { return "hmac" }
+
+// Authenticate verifies the signature, the timestamp and the nonce of the token,
+// It returns the metadata of the key that signs the token.
+func (a *HMACAuth) Authenticate(payload string) (metadata.MD, bool) {
+	parts := strings.Split(payload, ":")
+	if len(parts) != 4 {
+		return nil, false
+	}
+	keyID, timestamp, nonce, signature := parts[0], parts[1], parts[2], parts[3]
+
+	key, ok := a.keys[keyID]
+	if !ok {
+		return nil, false
+	}
+
+	expected := hmacSignature(key.Secret, keyID, timestamp, nonce)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, false
+	}
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	signedAt := time.Unix(sec, 0)
+
+	now := a.now()
+	if signedAt.Before(now.Add(-a.maxSkew)) || signedAt.After(now.Add(a.maxSkew)) {
+		return nil, false
+	}
+
+	if !a.replay.markSeen(keyID+":"+nonce, signedAt.Add(a.maxSkew), now) {
+		return nil, false
+	}
+
+	return principal(key.Metadata), true
+}
+
+// markSeen records the token until it expires, It returns false if the token has been seen.
+func (c *replayCache) markSeen(token string, expiresAt, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, v := range c.seen {
+		if now.After(v) {
+			delete(c.seen, k)
+		}
+	}
+	if _, ok := c.seen[token]; ok {
+		return false
+	}
+	c.seen[token] = expiresAt
+
+	return true
+}
+
+// SignHMACToken signs a token with the key at time t, the nonce is generated randomly.
+// The returned token can be passed to `NewCredential` with the prefix `hmac:`.
+func SignHMACToken(keyID string, secret []byte, t time.Time) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(b)
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+
+	return strings.Join([]string{keyID, timestamp, nonce, hmacSignature(secret, keyID, timestamp, nonce)}, ":"), nil
+}
+
+func hmacSignature(secret []byte, keyID, timestamp, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(keyID + ":" + timestamp + ":" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// JWTOption is the option of JWTAuth.
+type JWTOption struct {
+	// Keys verifies the signatures, The keys of the map are the key ids (the `kid` header),
+	// the empty key id is used if the token has no `kid` header.
+	// The values are []byte for HS256, *rsa.PublicKey for RS256 and *ecdsa.PublicKey for ES256.
+	Keys map[string]any
+	// Issuer is the expected `iss` claim, It is not verified if empty.
+	Issuer string
+	// Audience is the expected `aud` claim, It is not verified if empty.
+	Audience string
+	// Leeway is the allowed clock skew when verifying `exp` and `nbf`.
+	Leeway time.Duration
+	// AllowNoExpiry accepts the tokens without the `exp` claim, such tokens never expire.
+	// The tokens without `exp` are rejected by default.
+	AllowNoExpiry bool
+	// Claims maps the claims into the returned metadata, The keys are the claim names
+	// and the values are the metadata keys. Only string, number and boolean claims can be mapped.
+	Claims map[string]string
+}
+
+// JWTAuth authenticates clients with JWTs signed by HS256, RS256 or ES256,
+// The credential of client is in the form of `jwt:<token>`.
+type JWTAuth struct {
+	option *JWTOption
+	now    func() time.Time
+}
+
+var _ Authentication = (*JWTAuth)(nil)
+
+// NewJWTAuth returns a JWTAuth with the option.
+func NewJWTAuth(option *JWTOption) *JWTAuth {
+	return &JWTAuth{
+		option: option,
+		now:    time.Now,
+	}
+}
+
+// Name returns the name of JWTAuth.
+func (a *JWTAuth) Name() string { return "jwt" }
+
+// Authenticate verifies the signature and the claims of the token,
+// It returns the metadata mapped from the claims.
+func (a *JWTAuth) Authenticate(payload string) (metadata.MD, bool) {
+	claims, err := a.verify(payload)
+	if err != nil {
+		return nil, false
+	}
+
+	md := metadata.MD{}
+	for claim, key := range a.option.Claims {
+		v, ok := claims[claim]
+		if !ok {
+			continue
+		}
+		var value string
+		switch vv := v.(type) {
+		case string:
+			value = vv
+		case json.Number:
+			value = vv.String()
+		case bool:
+			value = fmt.Sprint(vv)
+		default:
+			continue
+		}
+		if err := md.Set(key, value); err != nil {
+			return nil, false
+		}
+	}
+	if exp, ok := claims["exp"]; ok {
+		t, _ := jwtTime(exp)
+		md.Set(ExpiresAtKey, strconv.FormatInt(t.Unix(), 10))
+	}
+
+	return md, true
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify verifies the token and returns its claims.
+func (a *JWTAuth) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("jwt: malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	key, ok := a.option.Keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("jwt: unknown key id: %s", header.Kid)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	return claims, a.verifyClaims(claims)
+}
+
+func (a *JWTAuth) verifyClaims(claims map[string]any) error {
+	now := a.now()
+
+	if exp, ok := claims["exp"]; ok {
+		t, err := jwtTime(exp)
+		if err != nil {
+			return err
+		}
+		if !now.Before(t.Add(a.option.Leeway)) {
+			return errors.New("jwt: token is expired")
+		}
+	} else if !a.option.AllowNoExpiry {
+		return errors.New("jwt: token has no exp claim")
+	}
+
+	if nbf, ok := claims["nbf"]; ok {
+		t, err := jwtTime(nbf)
+		if err != nil {
+			return err
+		}
+		if now.Add(a.option.Leeway).Before(t) {
+			return errors.New("jwt: token is not valid yet")
+		}
+	}
+
+	if a.option.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.option.Issuer {
+			return errors.New("jwt: invalid issuer")
+		}
+	}
+
+	if a.option.Audience != "" && !jwtHasAudience(claims["aud"], a.option.Audience) {
+		return errors.New("jwt: invalid audience")
+	}
+
+	return nil
+}
+
+func verifyJWTSignature(alg string, key any, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return errors.New("jwt: key is not a HS256 key")
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("jwt: invalid signature")
+		}
+		return nil
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("jwt: key is not a RS256 key")
+		}
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature)
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("jwt: key is not a ES256 key")
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return errors.New("jwt: invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("jwt: unsupported algorithm: %s", alg)
+	}
+}
+
+func decodeJWTSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func jwtTime(v any) (time.Time, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, errors.New("jwt: invalid time claim")
+	}
+	sec, err := n.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(sec), 0), nil
+}
+
+func jwtHasAudience(aud any, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwk is a JSON Web Key, only RSA and EC P-256 public keys are supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKS loads the public keys from a local JWKS file, The returned keys can be used as `JWTOption.Keys`.
+func LoadJWKS(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]any, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, err
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("jwt: unsupported curve: %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("jwt: invalid EC key")
+		}
+		// validate that the point is on the curve.
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("jwt: unsupported key type: %s", k.Kty)
+	}
+}
+
+// ParsePublicKeyPEM parses a PEM encoded RSA or ECDSA public key, The returned key can be used in `JWTOption.Keys`.
+func ParsePublicKeyPEM(data []byte) (any, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("jwt: invalid PEM data")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
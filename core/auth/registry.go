@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// Registry is a concurrency-safe set of authentications, Each server should have its own registry.
+// The authentications can be registered or replaced at runtime, the clients that have been authenticated
+// are not affected, the changes only apply to the following authentications.
+//
+// The registry authenticates clients as a `Chain` in the order of registration, and it denies
+// anonymous clients unless `AllowAnonymous` is called.
+type Registry struct {
+	mu sync.RWMutex
+	// chain is immutable and replaced on write, so it can be used without holding the lock.
+	chain *Chain
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{chain: &Chain{}}
+}
+
+// defaultRegistry is used by the package-level functions.
+var defaultRegistry = NewRegistry()
+
+// Default returns the default registry, `Register` and `RegisterChallenge` register authentications to it.
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// Register registers authentication, It replaces the registered one that has the same name.
+func (r *Registry) Register(authentication Authentication) {
+	r.register(link{name: authentication.Name(), auth: authentication})
+}
+
+// RegisterChallenge registers challenge authentication, It replaces the registered one that has the same name.
+func (r *Registry) RegisterChallenge(authentication ChallengeAuthentication) {
+	r.register(link{name: authentication.Name(), challengeAuth: authentication})
+}
+
+func (r *Registry) register(l link) {
+	r.update(func(c *Chain) {
+		for i, ll := range c.links {
+			if ll.name == l.name {
+				inherit(l, ll)
+				c.links[i] = l
+				return
+			}
+		}
+		c.links = append(c.links, l)
+	})
+}
+
+// inheritor is implemented by the authentications that keep state across replacements,
+// for example, the used tokens of HMACAuth.
+type inheritor interface {
+	// inherit takes over the state of the replaced authentication of the same name.
+	inherit(old any)
+}
+
+// inherit passes the state of the replaced link old to l.
+func inherit(l, old link) {
+	a, o := any(l.auth), any(old.auth)
+	if l.auth == nil {
+		a, o = l.challengeAuth, old.challengeAuth
+	}
+	if i, ok := a.(inheritor); ok && o != nil {
+		i.inherit(o)
+	}
+}
+
+// SetCertAuth sets the certificate authentication, nil disables it.
+func (r *Registry) SetCertAuth(cert *CertAuth) {
+	r.update(func(c *Chain) { c.cert = cert })
+}
+
+// AllowAnonymous allows anonymous clients with md, nil denies anonymous clients.
+func (r *Registry) AllowAnonymous(md metadata.MD) {
+	r.update(func(c *Chain) { c.anonymous = md.Clone() })
+}
+
+// Unregister unregisters the authentications by name.
+func (r *Registry) Unregister(name string) {
+	r.update(func(c *Chain) {
+		links := c.links[:0]
+		for _, l := range c.links {
+			if l.name != name {
+				links = append(links, l)
+			}
+		}
+		c.links = links
+	})
+}
+
+// Replace replaces all the authentications in the registry at once,
+// The authentications are used in order, the challenge authentications follow the authentications.
+func (r *Registry) Replace(auths []Authentication, challengeAuths []ChallengeAuthentication) {
+	links := make([]link, 0, len(auths)+len(challengeAuths))
+	for _, a := range auths {
+		links = append(links, link{name: a.Name(), auth: a})
+	}
+	for _, a := range challengeAuths {
+		links = append(links, link{name: a.Name(), challengeAuth: a})
+	}
+
+	r.update(func(c *Chain) {
+		for _, l := range links {
+			for _, old := range c.links {
+				if old.name == l.name {
+					inherit(l, old)
+					break
+				}
+			}
+		}
+		c.links = links
+	})
+}
+
+// update copies the chain, modifies the copy with fn and replaces the chain with it.
+func (r *Registry) update(fn func(*Chain)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := *r.chain
+	c.links = append([]link(nil), r.chain.links...)
+	fn(&c)
+	r.chain = &c
+}
+
+// Chain returns the current chain of the registry.
+func (r *Registry) Chain() *Chain {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.chain
+}
+
+// Get gets authentication by name.
+func (r *Registry) Get(name string) (Authentication, bool) {
+	for _, l := range r.Chain().links {
+		if l.name == name && l.auth != nil {
+			return l.auth, true
+		}
+	}
+	return nil, false
+}
+
+// GetChallenge gets challenge authentication by name.
+func (r *Registry) GetChallenge(name string) (ChallengeAuthentication, bool) {
+	for _, l := range r.Chain().links {
+		if l.name == name && l.challengeAuth != nil {
+			return l.challengeAuth, true
+		}
+	}
+	return nil, false
+}
+
+// Authenticate authenticates the Object and the TLS state with the current chain of the registry,
+// It returns `*DeniedError` if the client is denied.
+func (r *Registry) Authenticate(obj *frame.AuthenticationFrame, state tls.ConnectionState, challenge ChallengeFunc) (metadata.MD, error) {
+	// the authentications may challenge the client, so they are called without holding the lock.
+	return r.Chain().Authenticate(obj, state, challenge)
+}
+
+// LoadFunc parses the content of a file to authentications.
+type LoadFunc func(data []byte) ([]Authentication, []ChallengeAuthentication, error)
+
+// WatchFile loads the authentications from the file into the registry with load, and reloads them
+// whenever the file changes. It checks the file every interval until ctx is done.
+//
+// The initial loading error is returned, the following reloading errors are passed to onError and
+// the registry keeps the authentications loaded last time.
+func (r *Registry) WatchFile(ctx context.Context, path string, interval time.Duration, load LoadFunc, onError func(error)) error {
+	info, err := r.loadFile(path, load)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			stat, err := os.Stat(path)
+			if err != nil {
+				onError(err)
+				continue
+			}
+			if stat.ModTime().Equal(info.ModTime()) && stat.Size() == info.Size() {
+				continue
+			}
+
+			reloaded, err := r.loadFile(path, load)
+			if err != nil {
+				onError(err)
+				continue
+			}
+			info = reloaded
+		}
+	}()
+
+	return nil
+}
+
+func (r *Registry) loadFile(path string, load LoadFunc) (os.FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	auths, challengeAuths, err := load(data)
+	if err != nil {
+		return nil, err
+	}
+	r.Replace(auths, challengeAuths)
+
+	return info, nil
+}
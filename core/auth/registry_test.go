@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	obj := &frame.AuthenticationFrame{AuthName: "token", AuthPayload: "a"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			r.Register(NewTokenAuth(Token{Token: "a"}))
+		}()
+		go func() {
+			defer wg.Done()
+			r.Authenticate(obj, tls.ConnectionState{}, nil)
+		}()
+	}
+	wg.Wait()
+
+	_, err := r.Authenticate(obj, tls.ConnectionState{}, nil)
+	assert.NoError(t, err)
+
+	r.Unregister("token")
+	_, ok := r.Get("token")
+	assert.False(t, ok)
+}
+
+func TestRegistryWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	// write replaces the file at once, so the watcher never reads a partially written file.
+	write := func(token string, mtime time.Time) {
+		data := `{"tokens": [{"token": "` + token + `", "metadata": {"role": "ingest"}}]}`
+		tmp := path + ".tmp"
+		assert.NoError(t, os.WriteFile(tmp, []byte(data), 0o600))
+		assert.NoError(t, os.Chtimes(tmp, mtime, mtime))
+		assert.NoError(t, os.Rename(tmp, path))
+	}
+	write("old", time.Now().Add(-time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the watcher may still be running when the test ends, so the errors are collected rather than reported by it.
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	r := NewRegistry()
+	err := r.WatchFile(ctx, path, 10*time.Millisecond, LoadConfig, func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	})
+	assert.NoError(t, err)
+
+	md, err := r.Authenticate(&frame.AuthenticationFrame{AuthName: "token", AuthPayload: "old"}, tls.ConnectionState{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.MD{"role": {"ingest"}}, md)
+
+	write("new", time.Now())
+
+	assert.Eventually(t, func() bool {
+		_, err := r.Authenticate(&frame.AuthenticationFrame{AuthName: "token", AuthPayload: "new"}, tls.ConnectionState{}, nil)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = r.Authenticate(&frame.AuthenticationFrame{AuthName: "token", AuthPayload: "old"}, tls.ConnectionState{}, nil)
+	assert.Error(t, err)
+
+	cancel()
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Empty(t, errs)
+}
+
+func TestRegistryReloadHMAC(t *testing.T) {
+	r := NewRegistry()
+	reload := func() {
+		auths, _, err := LoadConfig([]byte(`{"hmac": {"max_skew": "1m", "keys": {"device-1": {"secret": "secret"}}}}`))
+		assert.NoError(t, err)
+		r.Replace(auths, nil)
+	}
+	reload()
+
+	token, err := SignHMACToken("device-1", []byte("secret"), time.Now())
+	assert.NoError(t, err)
+	obj := &frame.AuthenticationFrame{AuthName: "hmac", AuthPayload: token}
+
+	_, err = r.Authenticate(obj, tls.ConnectionState{}, nil)
+	assert.NoError(t, err)
+
+	// the token used before reloading cannot be replayed after it.
+	reload()
+	_, err = r.Authenticate(obj, tls.ConnectionState{}, nil)
+	assert.Error(t, err)
+
+	r.Register(NewHMACAuth(map[string]HMACKey{"device-1": {Secret: []byte("secret")}}, time.Minute))
+	_, err = r.Authenticate(obj, tls.ConnectionState{}, nil)
+	assert.Error(t, err)
+}
+
+// prefixTokenAuth handles the tokens with the prefix only.
+type prefixTokenAuth struct {
+	*TokenAuth
+	prefix string
+}
+
+func (a *prefixTokenAuth) Match(payload string) bool { return strings.HasPrefix(payload, a.prefix) }
+
+func TestChain(t *testing.T) {
+	c, err := NewChain(
+		&prefixTokenAuth{NewTokenAuth(Token{Token: "a-1", Metadata: metadata.MD{"from": {"a"}}}), "a-"},
+		NewTokenAuth(Token{Token: "b-1", Metadata: metadata.MD{"from": {"b"}}}),
+	)
+	assert.NoError(t, err)
+
+	authenticate := func(c *Chain, name, payload string) (metadata.MD, error) {
+		return c.Authenticate(&frame.AuthenticationFrame{AuthName: name, AuthPayload: payload}, tls.ConnectionState{}, nil)
+	}
+
+	md, err := authenticate(c, "token", "a-1")
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.MD{"from": {"a"}}, md)
+
+	// fall through to the next one.
+	md, err = authenticate(c, "token", "b-1")
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.MD{"from": {"b"}}, md)
+
+	// the first one handles it and rejects it.
+	_, err = authenticate(c, "token", "a-2")
+	assert.EqualError(t, err, "auth: denied: credential is rejected by token authentication")
+
+	// no plugins never means allowing all.
+	_, err = authenticate(&Chain{}, "none", "")
+	assert.EqualError(t, err, "auth: denied: credential is required")
+
+	_, err = authenticate(c, "jwt", "x")
+	assert.EqualError(t, err, "auth: denied: no authentication handles credential jwt")
+
+	// anonymous.
+	anonymous := c.WithAnonymous(metadata.MD{"role": {"anonymous"}})
+	md, err = authenticate(anonymous, "none", "")
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.MD{"role": {"anonymous"}}, md)
+
+	_, err = authenticate(anonymous, "token", "a-2")
+	assert.Error(t, err)
+
+	_, err = NewChain("token")
+	assert.Error(t, err)
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/woorui/ydesign/core/metadata"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// MinSCRAMIterations is the minimum iteration count of SCRAM, It is the minimum recommended by RFC 7677.
+// The client refuses the exchange with fewer iterations, so a malicious server cannot weaken the proof
+// to make brute-forcing the password cheap.
+const MinSCRAMIterations = 4096
+
+// SCRAMUser is the stored credential of a user of SCRAMAuth, It does not contain the password.
+type SCRAMUser struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	// Metadata describes the user, It is returned once authenticated.
+	Metadata metadata.MD
+}
+
+// NewSCRAMUser derives the stored credential from the password.
+func NewSCRAMUser(password string, salt []byte, iterations int, md metadata.MD) SCRAMUser {
+	clientKey := scramClientKey(password, salt, iterations)
+	storedKey := sha256.Sum256(clientKey)
+
+	return SCRAMUser{
+		Salt:       salt,
+		Iterations: iterations,
+		StoredKey:  storedKey[:],
+		Metadata:   md,
+	}
+}
+
+// SCRAMAuth authenticates clients with a SCRAM-SHA-256-style nonce/proof exchange,
+// The password never crosses the wire. The credential of client is created by `NewSCRAMCredential`.
+//
+// The exchange is:
+//
+//	client: n=<user>,r=<client nonce>                     (AuthenticationFrame)
+//	server: r=<nonce>,s=<base64 salt>,i=<iterations>      (AuthenticationChallengeFrame)
+//	client: r=<nonce>,p=<base64 proof>                    (AuthenticationChallengeFrame)
+//	server: AuthenticationAckFrame or RejectedFrame
+//
+// The unknown users are challenged with a fake salt and iteration count too and rejected after the proof,
+// so the exchange does not tell whether a user exists.
+type SCRAMAuth struct {
+	users map[string]SCRAMUser
+	// fakeKey derives the fake salts of the unknown users, fakeIterations is their iteration count.
+	fakeKey        []byte
+	fakeIterations int
+}
+
+var _ ChallengeAuthentication = (*SCRAMAuth)(nil)
+
+// NewSCRAMAuth returns a SCRAMAuth with the users, the keys of the map are user names.
+func NewSCRAMAuth(users map[string]SCRAMUser) *SCRAMAuth {
+	fakeKey := make([]byte, sha256.Size)
+	if _, err := rand.Read(fakeKey); err != nil {
+		panic(err)
+	}
+	// the fake users have the highest iteration count, so they are not told apart by it.
+	fakeIterations := MinSCRAMIterations
+	for _, user := range users {
+		if user.Iterations > fakeIterations {
+			fakeIterations = user.Iterations
+		}
+	}
+	return &SCRAMAuth{users: users, fakeKey: fakeKey, fakeIterations: fakeIterations}
+}
+
+// fakeUser returns the user challenged in place of the unknown user name, Its salt is the same for the same name
+// and it never matches a proof.
+func (a *SCRAMAuth) fakeUser(name string) SCRAMUser {
+	salt := scramHMAC(a.fakeKey, name)[:16]
+	return SCRAMUser{Salt: salt, Iterations: a.fakeIterations}
+}
+
+// Name returns the name of SCRAMAuth.
+func (a *SCRAMAuth) Name() string { return "scram-sha-256" }
+
+// AuthenticateChallenge runs the exchange and verifies the proof of client.
+func (a *SCRAMAuth) AuthenticateChallenge(payload string, challenge ChallengeFunc) (metadata.MD, bool) {
+	clientFirst := payload
+	attrs := parseSCRAM(clientFirst)
+
+	if attrs["n"] == "" || attrs["r"] == "" {
+		return nil, false
+	}
+	user, known := a.users[attrs["n"]]
+	if !known {
+		user = a.fakeUser(attrs["n"])
+	}
+
+	serverNonce, err := scramNonce()
+	if err != nil {
+		return nil, false
+	}
+	nonce := attrs["r"] + serverNonce
+
+	serverFirst := fmt.Sprintf("r=%s,s=%s,i=%d", nonce, base64.StdEncoding.EncodeToString(user.Salt), user.Iterations)
+	clientFinal, err := challenge(serverFirst)
+	if err != nil {
+		return nil, false
+	}
+
+	attrs = parseSCRAM(clientFinal)
+	if attrs["r"] != nonce {
+		return nil, false
+	}
+	proof, err := base64.StdEncoding.DecodeString(attrs["p"])
+	if err != nil || len(proof) != sha256.Size {
+		return nil, false
+	}
+
+	// ClientKey = ClientProof XOR ClientSignature, and StoredKey = H(ClientKey).
+	signature := scramHMAC(user.StoredKey, scramAuthMessage(clientFirst, serverFirst, nonce))
+	clientKey := make([]byte, len(proof))
+	subtle.XORBytes(clientKey, proof, signature)
+	storedKey := sha256.Sum256(clientKey)
+
+	if subtle.ConstantTimeCompare(storedKey[:], user.StoredKey) != 1 || !known {
+		return nil, false
+	}
+
+	return principal(user.Metadata), true
+}
+
+// NewSCRAMCredential returns the client credential of SCRAMAuth,
+// Every exchange started by its Payload has a fresh client nonce, so it can be used to reconnect and reauthenticate.
+func NewSCRAMCredential(user, password string) (ChallengeCredential, error) {
+	if user == "" || strings.ContainsAny(user, ",=") {
+		return nil, errors.New("auth: scram user cannot be empty or contain ',' or '='")
+	}
+	return &scramCredential{user: user, password: password}, nil
+}
+
+type scramCredential struct {
+	user     string
+	password string
+
+	// mu guards the client-first message of the current exchange.
+	mu          sync.Mutex
+	clientFirst string
+	nonce       string
+}
+
+func (c *scramCredential) Name() string { return "scram-sha-256" }
+
+// Payload starts a new exchange with a fresh client nonce, The server rejects the exchange
+// if the nonce cannot be generated.
+func (c *scramCredential) Payload() string {
+	nonce, _ := scramNonce()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nonce = nonce
+	c.clientFirst = "n=" + c.user + ",r=" + nonce
+	return c.clientFirst
+}
+
+// Respond answers the server-first message of the exchange started by the last Payload.
+func (c *scramCredential) Respond(serverFirst string) (string, error) {
+	c.mu.Lock()
+	clientFirst, clientNonce := c.clientFirst, c.nonce
+	c.mu.Unlock()
+
+	attrs := parseSCRAM(serverFirst)
+
+	nonce := attrs["r"]
+	if clientNonce == "" || !strings.HasPrefix(nonce, clientNonce) || len(nonce) == len(clientNonce) {
+		return "", errors.New("auth: scram server nonce is invalid")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		return "", err
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations <= 0 {
+		return "", errors.New("auth: scram iterations is invalid")
+	}
+	if iterations < MinSCRAMIterations {
+		return "", fmt.Errorf("auth: scram iterations %d is less than %d", iterations, MinSCRAMIterations)
+	}
+
+	clientKey := scramClientKey(c.password, salt, iterations)
+	storedKey := sha256.Sum256(clientKey)
+	signature := scramHMAC(storedKey[:], scramAuthMessage(clientFirst, serverFirst, nonce))
+
+	proof := make([]byte, len(clientKey))
+	subtle.XORBytes(proof, clientKey, signature)
+
+	return "r=" + nonce + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func scramClientKey(password string, salt []byte, iterations int) []byte {
+	salted := pbkdf2.Key([]byte(password), salt, iterations, sha256.Size, sha256.New)
+	return scramHMAC(salted, "Client Key")
+}
+
+func scramAuthMessage(clientFirst, serverFirst, nonce string) string {
+	return clientFirst + "," + serverFirst + ",r=" + nonce
+}
+
+func scramHMAC(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func scramNonce() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// parseSCRAM parses the SCRAM message `k1=v1,k2=v2` into a map.
+func parseSCRAM(message string) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(message, ",") {
+		if k, v, ok := strings.Cut(attr, "="); ok {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
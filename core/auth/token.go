@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"crypto/subtle"
+
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// Token is a static token and the metadata describing its principal.
+type Token struct {
+	// Token is the secret token.
+	Token string
+	// Metadata describes the principal of the token, It is returned once authenticated.
+	Metadata metadata.MD
+}
+
+// TokenAuth authenticates clients with a static list of tokens,
+// The credential of client is in the form of `token:<token>`.
+type TokenAuth struct {
+	tokens []Token
+}
+
+var _ Authentication = (*TokenAuth)(nil)
+
+// NewTokenAuth returns a TokenAuth with the tokens.
+func NewTokenAuth(tokens ...Token) *TokenAuth {
+	return &TokenAuth{tokens: tokens}
+}
+
+// Name returns the name of TokenAuth.
+func (a *TokenAuth) Name() string { return "token" }
+
+// Authenticate compares the payload with every token in constant time,
+// It returns the metadata of the matched token.
+func (a *TokenAuth) Authenticate(payload string) (metadata.MD, bool) {
+	var matched *Token
+	for i := range a.tokens {
+		// compare with all the tokens to avoid leaking which one is matched.
+		if subtle.ConstantTimeCompare([]byte(a.tokens[i].Token), []byte(payload)) == 1 && matched == nil {
+			matched = &a.tokens[i]
+		}
+	}
+	if matched == nil {
+		return nil, false
+	}
+	return principal(matched.Metadata), true
+}
+
+// principal returns a copy of md, it never returns nil.
+func principal(md metadata.MD) metadata.MD {
+	if md == nil {
+		return metadata.MD{}
+	}
+	return md.Clone()
+}
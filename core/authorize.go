@@ -0,0 +1,125 @@
+package core
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/woorui/ydesign/core/acl"
+	"github.com/woorui/ydesign/core/auth"
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// verifyAuthentication is the VerifyAuthenticationFunc of the server, it authenticates clients with the registry of server.
+func (s *Server) verifyAuthentication(obj *frame.AuthenticationFrame, state tls.ConnectionState, challenge auth.ChallengeFunc) (metadata.MD, bool, error) {
+	md, err := s.option.Auth.Authenticate(obj, state, challenge)
+	if err != nil {
+		return md, false, err
+	}
+	if err := s.option.MetadataLimits.Check(md); err != nil {
+		code, _ := metadataRejectCode(err)
+		return nil, false, &RejectedError{Code: code, Message: "authentication failed: " + err.Error()}
+	}
+	return md, true, nil
+}
+
+// authorize checks whether the client of conn is allowed to perform action on tag,
+// If not, the client will receive a RejectedFrame. It allows every action if there is no ACL.
+func (s *Server) authorize(conn ServerConnection, action acl.Action, tag string) bool {
+	err := s.checkACL(conn, action, tag)
+	if err == nil {
+		return true
+	}
+
+	if err := conn.Reject(RejectCodeForbidden, err.Error()); err != nil {
+		s.logger.Debug("server write rejected frame failed", "conn_id", conn.ID(), "err", err)
+	}
+	s.logger.Debug("client is forbidden", "conn_id", conn.ID(), "action", action.String(), "tag", tag)
+
+	return false
+}
+
+// checkACL returns an error if the client of conn is not allowed to perform action on tag.
+func (s *Server) checkACL(conn ServerConnection, action acl.Action, tag string) error {
+	if s.option.ACL == nil || s.option.ACL.Allow(conn.Metadata(), action, tag) {
+		return nil
+	}
+	return fmt.Errorf("forbidden: not allowed to %s tag %s", action.String(), tag)
+}
+
+// serve docks the streams opened by the authenticated client of ctrl and
+// handles its observe requests, It returns when the control stream is closed.
+func (s *Server) serve(ctrl *ServerController) {
+	session, err := s.tenants.join(ctrl.Metadata())
+	if err != nil {
+		s.logger.Debug("client is rejected by tenant", "conn_id", ctrl.ID(), "error", err)
+		code := RejectCodeLimitExceeded
+		if errors.Is(err, errNoTenant) {
+			code = RejectCodeForbidden
+		}
+		ctrl.rejectWithCloseConn(code, err.Error())
+		return
+	}
+	defer session.leave()
+
+	// the name is claimed once the client is admitted by its tenant, so a rejected client never takes over a session.
+	key := nameKey(ctrl, session)
+	old, err := s.names.claim(key, ctrl)
+	if err != nil {
+		s.logger.Debug("client is rejected by duplicate name", "conn_id", ctrl.ID(), "name", ctrl.Name())
+		ctrl.rejectWithCloseConn(RejectCodeDuplicateName, err.Error())
+		return
+	}
+	defer s.names.release(key, ctrl)
+
+	var inherited []string
+	if old != nil {
+		inherited = s.takeover(old, ctrl)
+	}
+
+	if s.option.Reauth != nil {
+		go s.reauthenticateLoop(ctrl)
+	}
+
+	s.handleConn(ctrl, session)
+	defer s.unobserve(ctrl.ID())
+
+	for _, tag := range inherited {
+		s.observe(ctrl, session, tag)
+	}
+	for tag := range ctrl.observeChan {
+		s.observe(ctrl, session, tag)
+	}
+}
+
+// observe makes the client of ctrl observe the tag if it is allowed,
+// The rejection carries the tag, so the client returns it to the caller observing the tag.
+func (s *Server) observe(ctrl *ServerController, session *tenantSession, tag string) {
+	if err := s.checkACL(ctrl, acl.Observe, tag); err != nil {
+		s.rejectObserve(ctrl, tag, RejectCodeForbidden, err)
+		return
+	}
+	if err := session.useTag(tag); err != nil {
+		s.rejectObserve(ctrl, tag, RejectCodeLimitExceeded, err)
+		return
+	}
+	ctrl.addObserved(tag)
+	s.Observe(session.namespace(tag), ctrl)
+}
+
+// rejectObserve tells the client of ctrl that its request to observe the tag is rejected.
+func (s *Server) rejectObserve(ctrl *ServerController, tag string, code RejectCode, err error) {
+	if err := ctrl.rejectObserve(tag, code, err.Error()); err != nil {
+		s.logger.Debug("server write rejected frame failed", "conn_id", ctrl.ID(), "err", err)
+	}
+	s.logger.Debug("client is rejected to observe", "conn_id", ctrl.ID(), "tag", tag, "code", code.String(), "error", err)
+}
+
+// rejectLimitExceeded tells the client of conn that it exceeds the limits of its tenant.
+func (s *Server) rejectLimitExceeded(conn ServerConnection, err error) {
+	if err := conn.Reject(RejectCodeLimitExceeded, err.Error()); err != nil {
+		s.logger.Debug("server write rejected frame failed", "conn_id", conn.ID(), "err", err)
+	}
+	s.logger.Debug("client exceeds the limits of tenant", "conn_id", conn.ID(), "error", err)
+}
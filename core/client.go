@@ -2,61 +2,113 @@ package core
 
 import (
 	"context"
-	"errors"
-	"io"
+	"fmt"
+	"sync"
 
 	"github.com/quic-go/quic-go"
-	"github.com/woorui/ydesign/core/frame"
-	"golang.org/x/exp/slog"
+	"github.com/woorui/ydesign/core/metadata"
 )
 
 // Client represents a peer in a network that can open writers and observe other writers,
 // and handle them in an consumer.
 type Client struct {
 	conn   UniStreamPeerConnection
+	frw    *FrameReadWriter
 	option *ClientOption
-}
 
-// ClientOption is the option to create a client.
-type ClientOption struct {
-	Codec       frame.Codec
-	PacketCodec frame.PacketCodec
-	logger      *slog.Logger
-	IDGenerator func() string
+	// rejections delivers the rejections of observe requests to the callers of Observe.
+	rejections *observeRejections
+	// acks delivers the acknowledgements of the streams opened, It is nil if the connection does not report them.
+	acks *streamAcks
+
+	// ctx is canceled when the client is closed, the contexts of the observed streams derive from it.
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+
+	// acceptDone is closed when the accepting loop exits, acceptErr is the reason.
+	acceptDone chan struct{}
+	acceptErr  error
+
+	// mu guards the fields below.
+	mu sync.Mutex
+	// observers is the observer of every tag observed, the streams accepted are dispatched by their tags.
+	observers map[string]Observer
+	// accepting is true after the accepting loop is started.
+	accepting bool
 }
 
-func initOption(o *ClientOption) *ClientOption {
-	if o == nil {
-		// fill option with defaults.
-		return o
-	}
+// observeRejectionNotifier is implemented by the connections that report the rejections of observe requests.
+type observeRejectionNotifier interface {
+	OnObserveRejected(func(tag string, err *RejectedError))
+}
 
-	return o
+// streamAckNotifier is implemented by the connections that report the acknowledgements of the streams opened.
+type streamAckNotifier interface {
+	OnStreamAcked(func(id string, err *RejectedError))
 }
 
-// NewClient returns a new Client from a connection.
-func NewClient(conn UniStreamPeerConnection, option *ClientOption) *Client {
-	option = initOption(option)
+// NewClient returns a new Client from a connection, The zero fields of option are filled with the defaults.
+func NewClient(conn UniStreamPeerConnection, option *ClientOption) (*Client, error) {
+	option, err := initOption(option)
+	if err != nil {
+		return nil, err
+	}
 
+	ctx, ctxCancel := context.WithCancel(context.Background())
 	client := &Client{
-		conn:   conn,
-		option: option,
+		conn:       conn,
+		frw:        NewFrameReadWriter(option.PacketCodec, option.Codec).WithMetadataLimits(*option.MetadataLimits),
+		option:     option,
+		rejections: newObserveRejections(),
+		ctx:        ctx,
+		ctxCancel:  ctxCancel,
+		observers:  make(map[string]Observer),
+		acceptDone: make(chan struct{}),
+	}
+	if n, ok := conn.(observeRejectionNotifier); ok {
+		n.OnObserveRejected(client.rejections.reject)
+	}
+	if n, ok := conn.(streamAckNotifier); ok {
+		client.acks = newStreamAcks()
+		n.OnStreamAcked(client.acks.ack)
 	}
 
-	return client
+	return client, nil
 }
 
-func OpenClient(ctx context.Context, addr string) (*Client, error) {
-	qconn, err := quic.DialAddr(ctx, addr, nil, nil)
+// OpenClient dials the server at addr, authenticates with the credential in opts and returns a ready Client.
+// The authentication fails with a `*RejectedError` if the server rejects the client.
+// The client reconnects when the connection is lost if `WithReconnect` is given, the streams observed
+// are observed again after reconnecting.
+func OpenClient(ctx context.Context, addr string, opts ...ClientOptionFunc) (*Client, error) {
+	option, err := NewClientOption(opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	stream0, err := qconn.OpenStreamSync(ctx)
+	var conn UniStreamPeerConnection
+	if option.Reconnect != nil {
+		conn, err = openReconnectingConn(ctx, addr, option, dialController)
+	} else {
+		conn, err = dialController(ctx, addr, option)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	client, err := NewClient(conn, option)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	option.Logger.Debug("client is authenticated", "conn_id", conn.ID(), "name", option.Name)
+
+	return client, nil
+}
+
+// ID returns the ID of the client, It is assigned by the server.
+func (c *Client) ID() string {
+	return c.conn.ID()
 }
 
 type baseConnection struct {
@@ -64,93 +116,308 @@ type baseConnection struct {
 	stream0 quic.Stream
 }
 
-type Writer struct {
-	tag        string
-	opener     WriterOpener
-	wrCh       chan []byte
-	rdCh       chan int
-	openrCh    chan WriterOpener
-	underlying io.WriteCloser
+// Open opens a writer with the given tag, which other peers can observe.
+// The returned writer can be used to write to the stream associated with the given tag,
+// the md is carried in the header of the stream and will be received by the observers.
+func (c *Client) Open(tag string, md metadata.MD) (WriteStream, error) {
+	w, _, err := c.open(tag, md)
+	return w, err
 }
 
-type WriterOpenerOpener interface {
+// open is Open that also returns the ID of the stream, the server acknowledges the stream by it.
+func (c *Client) open(tag string, md metadata.MD) (WriteStream, string, error) {
+	id := c.option.IDGenerator()
+	md, err := c.streamMetadata(tag, id, md)
+	if err != nil {
+		return nil, "", err
+	}
+
+	w, err := c.conn.OpenUniStream()
+	if err != nil {
+		return nil, "", asRejectedError(err)
+	}
+
+	header := &StreamHeader{
+		ID:       id,
+		Tag:      tag,
+		Metadata: md,
+	}
+	if err := writeStreamHeader(c.frw, w, header); err != nil {
+		w.Close()
+		return nil, "", err
+	}
+
+	c.option.Logger.Debug("client opens a writer", "tag", tag, "stream_id", header.ID)
+
+	return w, id, nil
 }
 
-func MustOpenWriter(opener WriterOpener, tag string) io.Writer {
-	for {
-		select {
-		case <-opener.Context().Done():
+// connDone returns a channel that is closed when the current connection is lost,
+// It is nil if the connection does not tell, and it is closed if the client is reconnecting.
+func (c *Client) connDone() <-chan struct{} {
+	switch conn := c.conn.(type) {
+	case *reconnectingConn:
+		if ctrl, _, err := conn.current(); err == nil && ctrl != nil {
+			return ctrl.Done()
+		}
+		done := make(chan struct{})
+		close(done)
+		return done
+	case interface{ Done() <-chan struct{} }:
+		return conn.Done()
+	}
+	return nil
+}
 
+// streamMetadata returns the metadata carried in the header of the stream with the tag and the ID,
+// It is signed for the stream if the signing key is set and it is checked against the metadata limits.
+func (c *Client) streamMetadata(tag, id string, md metadata.MD) (metadata.MD, error) {
+	if len(c.option.MetadataSigningKey) > 0 {
+		md = md.Clone()
+		if md == nil {
+			md = metadata.MD{}
+		}
+		if err := md.SignStream(c.option.MetadataSigningKey, tag, id); err != nil {
+			return nil, err
 		}
 	}
+	if err := c.option.MetadataLimits.Check(md); err != nil {
+		return nil, err
+	}
+	return md, nil
 }
 
-func (w *Writer) Write(p []byte) (int, error) {
-	var (
-		opener = w.opener
-		writer = w.underlying
-		err    error
-	)
+// Observe observes tagged streams and handles them in an observer.
+// The observer is responsible for handling the tagged streams and writing to a new peer stream.
+// It returns a `*RejectedError` if the server rejects observing the tag, for example, `RejectCodeForbidden`.
+// A tag can only be observed by one observer at a time.
+func (c *Client) Observe(tag string, observer Observer) error {
+	if err := c.addObserver(tag, observer); err != nil {
+		return err
+	}
+	defer c.removeObserver(tag)
 
+	// wait for the rejection before requesting, so that a quick rejection is not missed.
+	rejected, stop := c.rejections.wait(tag)
+	defer stop()
+
+	// client request to observe stream in the specified tag.
+	err := c.conn.RequestObserve(tag)
+	if err != nil {
+		return asRejectedError(err)
+	}
+	// then waiting for the rejection or the end of accepting, the streams are handled by the accepting loop.
 	select {
-	case opener := <-w.openrCh:
-		writer, err = opener.Open(w.tag)
-		if err == errors.New("client: be rejected") {
-			return 0, err
+	case err := <-rejected:
+		return err
+	case <-c.acceptDone:
+		return asRejectedError(c.acceptErr)
+	}
+}
+
+// addObserver registers the observer of tag and starts the accepting loop if it is not started.
+func (c *Client) addObserver(tag string, observer Observer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.observers[tag]; ok {
+		return fmt.Errorf("client: tag %s is already observed", tag)
+	}
+	c.observers[tag] = observer
+	if !c.accepting {
+		c.accepting = true
+		go c.accept()
+	}
+	return nil
+}
+
+func (c *Client) removeObserver(tag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.observers, tag)
+}
+
+func (c *Client) observer(tag string) (Observer, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	observer, ok := c.observers[tag]
+	return observer, ok
+}
+
+// accept accepts the streams on the connection and dispatches them to the observers by the tags in the headers,
+// The streams of the tags not observed are canceled with `RejectCodeNotObserved`.
+func (c *Client) accept() {
+	defer close(c.acceptDone)
+
+	for {
+		// accept the reader and read the header from it.
+		r, err := c.conn.AcceptUniStream(c.ctx)
+		if err != nil {
+			c.acceptErr = err
+			return
+		}
+		header, err := readStreamHeader(c.frw, r)
+		if err != nil {
+			c.option.Logger.Debug("failed to read stream header", "error", err)
+			r.CancelRead(streamErrorCode(err))
+			continue
+		}
+		observer, ok := c.observer(header.Tag)
+		if !ok {
+			c.option.Logger.Debug("stream tag is not observed", "tag", header.Tag, "stream_id", header.ID)
+			r.CancelRead(RejectCodeNotObserved.StreamErrorCode())
+			continue
 		}
-	case p := <-w.wrCh:
 
+		// dispatch the header, reader and writer to the observer.
+		go c.handle(observer, header, r)
 	}
+}
 
-	io.Pipe()
+// handle calls the observer with the stream, The context of the opener is canceled
+// when the observer returns or the client is closed.
+func (c *Client) handle(observer Observer, header *StreamHeader, r ReadStream) {
+	ctx, cancel := context.WithCancel(metadata.NewContext(c.ctx, header.Metadata))
+	defer cancel()
 
-	return w.underlying.Write(p)
+	observer.Handle(&observedOpener{ctx: ctx, client: c}, header, r)
 }
 
-func (w *Writer) beRejected(err error) bool {
-	return false
+// observedOpener is the WriterOpener of observers,
+// The streams opened by it inherit the metadata of the observed stream.
+type observedOpener struct {
+	ctx    context.Context
+	client *Client
 }
 
-// Open opens a writer with the given tag, which other peers can observe.
-// The returned writer can be used to write to the stream associated with the given tag.
-func (c *Client) Open(tag string) (io.WriteCloser, error) {
-	w, err := c.conn.OpenUniStream()
-	if err != nil {
-		return nil, err
+// Context returns the context carrying the metadata of the observed stream,
+// It is canceled when the observer returns or the client is closed.
+func (o *observedOpener) Context() context.Context { return o.ctx }
+
+// Open opens a writer with the given tag, the md inherits the allowed keys from the observed stream.
+func (o *observedOpener) Open(tag string, md metadata.MD) (WriteStream, error) {
+	incoming, _ := metadata.FromContext(o.ctx)
+	return o.client.Open(tag, inheritMetadata(md, incoming, o.client.option.InheritedMetadataKeys))
+}
+
+// inheritMetadata returns md with the keys copied from incoming, the keys in md are kept.
+func inheritMetadata(md, incoming metadata.MD, keys []string) metadata.MD {
+	if len(keys) == 0 || len(incoming) == 0 {
+		return md
 	}
 
-	c.option.logger.Debug("peer opens a writer", "tag", tag)
+	md = md.Clone()
+	if md == nil {
+		md = metadata.MD{}
+	}
+	for _, k := range keys {
+		if _, ok := md[k]; ok {
+			continue
+		}
+		if values := incoming.Values(k); len(values) > 0 {
+			md[k] = append([]string(nil), values...)
+		}
+	}
 
-	id := c.option.IDGenerator()
+	return md
+}
 
-	return w, c.fillWriterFunc(id, tag, w)
+// observeRejections delivers the rejections of observe requests to the callers observing the tags.
+type observeRejections struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan *RejectedError]struct{}
 }
 
-// Observe observes tagged streams and handles them in an observer.
-// The observer is responsible for handling the tagged streams and writing to a new peer stream.
-func (c *Client) Observe(tag string, observer Observer) error {
-	// peer request to observe stream in the specified tag.
-	err := p.conn.RequestObserve(tag)
-	if err != nil {
-		return err
+func newObserveRejections() *observeRejections {
+	return &observeRejections{waiters: make(map[string]map[chan *RejectedError]struct{})}
+}
+
+// wait returns a channel that receives the rejection of observing tag, stop must be called when the waiting ends.
+func (o *observeRejections) wait(tag string) (<-chan *RejectedError, func()) {
+	ch := make(chan *RejectedError, 1)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	m, ok := o.waiters[tag]
+	if !ok {
+		m = make(map[chan *RejectedError]struct{})
+		o.waiters[tag] = m
+	}
+	m[ch] = struct{}{}
+
+	stop := func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		m := o.waiters[tag]
+		delete(m, ch)
+		if len(m) == 0 {
+			delete(o.waiters, tag)
+		}
 	}
-	// then waiting and handling the stream reponsed by server.
-	return p.observing(observer)
+	return ch, stop
 }
 
-func (p *Peer) observing(observer Observer) error {
-	for {
-		// accept and pure the reader.
-		r, err := p.conn.AcceptUniStream(context.Background())
-		if err != nil {
-			return err
+// reject delivers err to the callers observing tag.
+func (o *observeRejections) reject(tag string, err *RejectedError) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for ch := range o.waiters[tag] {
+		select {
+		case ch <- err:
+		default:
 		}
+	}
+}
 
-		// dispatch the reader and writer to the observer.
-		go observer.Handle(p, r)
+// streamAcks delivers the acknowledgements of the streams opened to the writers waiting for them.
+type streamAcks struct {
+	mu      sync.Mutex
+	waiters map[string]chan *RejectedError
+}
+
+func newStreamAcks() *streamAcks {
+	return &streamAcks{waiters: make(map[string]chan *RejectedError)}
+}
+
+// wait returns a channel that receives the acknowledgement of the stream with the ID, the rejection is nil
+// if the stream is delivered. stop must be called when the waiting ends.
+func (a *streamAcks) wait(id string) (<-chan *RejectedError, func()) {
+	ch := make(chan *RejectedError, 1)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.waiters[id] = ch
+
+	stop := func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		delete(a.waiters, id)
+	}
+	return ch, stop
+}
+
+// ack delivers the acknowledgement of the stream with the ID, It is dropped if nobody waits for it.
+func (a *streamAcks) ack(id string, err *RejectedError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if ch, ok := a.waiters[id]; ok {
+		select {
+		case ch <- err:
+		default:
+		}
 	}
 }
 
-func (p *Peer) Close() error {
-	return p.conn.Close()
+// Close closes the client, the contexts of the observed streams are canceled.
+func (c *Client) Close() error {
+	c.ctxCancel()
+	return c.conn.Close()
 }
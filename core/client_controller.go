@@ -3,10 +3,9 @@ package core
 import (
 	"context"
 	"crypto/tls"
-	"errors"
 	"fmt"
-	"io"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/quic-go/quic-go"
 	"github.com/woorui/ydesign/core/auth"
@@ -26,18 +25,55 @@ type clientController struct {
 
 	// id is the connID of the client, It is assigned by server.
 	id string
+	// name is the stable name of the client, It is presented to server in authentication.
+	name string
+	// handshakeTimeout limits the time of authentication, 0 means no limit.
+	handshakeTimeout time.Duration
+
+	// mu guards the credentials and the writing of stream0.
+	mu sync.Mutex
+	// cred is the credential that authenticated the client, it is answered to the reauthentication
+	// of server if refresh is nil.
+	cred    auth.Credential
+	refresh func() (auth.Credential, error)
+	// onObserveRejected is called when the server rejects a request to observe a tag.
+	onObserveRejected func(tag string, err *RejectedError)
+	// onStreamAcked is called when the server acknowledges a stream opened.
+	onStreamAcked func(id string, err *RejectedError)
+	// err is the reason why the connection is closed.
+	err error
 
 	logger *slog.Logger
 }
 
 // ClientController is the interface that defines the methods for client-side control controller.
 type ClientController interface {
+	// ClientController opens and accepts uniStreams of the connection.
+	UniStreamPeerConnection
+
 	// Authenticate sends the provided credential to the server's control stream to authenticate the client.
-	// There will return `ErrAuthenticateFailed` if authenticate failed.
-	Authenticate(*auth.Credential) error
+	// There will return a `*RejectedError` if the server rejects the client, and it is `RejectCodeAuthFailed`
+	// if the credential is rejected.
+	// The credential answers the challenges from server if it is an `auth.ChallengeCredential`.
+	Authenticate(auth.Credential) error
+
+	// OnReauthenticate sets the function that returns fresh credential when server asks the client to reauthenticate,
+	// The credential used by `Authenticate` is answered if it is not set.
+	OnReauthenticate(func() (auth.Credential, error))
+
+	// OnObserveRejected sets the function called when the server rejects a request to observe a tag,
+	// for example, the tag is forbidden by the ACL or exceeds the limits of the tenant.
+	OnObserveRejected(func(tag string, err *RejectedError))
+
+	// OnStreamAcked sets the function called when the server acknowledges a stream opened by its ID,
+	// err is nil if the stream is delivered to an observer, otherwise it is the reason why the stream is canceled.
+	OnStreamAcked(func(id string, err *RejectedError))
 
-	// RequestObserve requests server to observe a tag.
-	RequestObserve(tag string) error
+	// Done returns a channel that is closed when the connection is closed.
+	Done() <-chan struct{}
+
+	// Err returns the reason why the connection is closed, It is a `*RejectedError` if the server closes it.
+	Err() error
 
 	// CloseWithError closes the client-side control stream.
 	CloseWithError(string) error
@@ -49,34 +85,46 @@ type ClientControllerOpener interface {
 	Open(ctx context.Context, addr string) (ClientController, error)
 }
 
-// ErrAuthenticateFailed be returned when client control stream authenticate failed.
-type ErrAuthenticateFailed struct {
-	ReasonFromeServer string
-}
-
-// Error returns a string that represents the ErrAuthenticateFailed error for the implementation of the error interface.
-func (e ErrAuthenticateFailed) Error() string { return e.ReasonFromeServer }
-
+// ClientControlStreamOpener dials the server and opens the client-side controller on the first stream.
 type ClientControlStreamOpener struct {
-	tlsConfig  *tls.Config
-	quicConfig *quic.Config
+	tlsConfig        *tls.Config
+	quicConfig       *quic.Config
+	frw              *FrameReadWriter
+	name             string
+	handshakeTimeout time.Duration
 
 	logger *slog.Logger
 }
 
+// NewClientControlStreamOpener returns a ClientControlStreamOpener that dials the server with option,
+// The option should be completed by `NewClientOption`.
+func NewClientControlStreamOpener(option *ClientOption) *ClientControlStreamOpener {
+	return &ClientControlStreamOpener{
+		tlsConfig:        option.TLSConfig,
+		quicConfig:       option.QUICConfig,
+		frw:              NewFrameReadWriter(option.PacketCodec, option.Codec).WithMetadataLimits(*option.MetadataLimits),
+		name:             option.Name,
+		handshakeTimeout: option.HandshakeTimeout,
+		logger:           option.Logger,
+	}
+}
+
+// Open dials the server at addr and opens the control stream, the ctx limits the dialing.
 func (opener *ClientControlStreamOpener) Open(ctx context.Context, addr string) (ClientController, error) {
 	conn, err := quic.DialAddr(ctx, addr, opener.tlsConfig, opener.quicConfig)
 	if err != nil {
 		return nil, err
 	}
-	stream0, err := conn.OpenStream()
+	stream0, err := conn.OpenStreamSync(ctx)
 	if err != nil {
+		conn.CloseWithError(RejectCodeClosed.ApplicationErrorCode(), err.Error())
 		return nil, err
 	}
-	// TODO:
-	fmt.Println(stream0)
 
-	return nil, nil
+	controller := NewClientController(ctx, conn, stream0, opener.frw, opener.name, opener.logger)
+	controller.handshakeTimeout = opener.handshakeTimeout
+
+	return controller, nil
 }
 
 // RequestObserve requests server to observe a tag.
@@ -84,6 +132,14 @@ func (cs *clientController) RequestObserve(tag string) error {
 	f := &frame.ObserveFrame{
 		Tag: tag,
 	}
+	return cs.writeFrame(f)
+}
+
+// writeFrame writes the frame to stream0, it is safe for concurrent use.
+func (cs *clientController) writeFrame(f frame.Frame) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
 	return cs.frw.WriteFrame(cs.stream0, f)
 }
 
@@ -93,12 +149,12 @@ func (cs *clientController) ID() string {
 }
 
 // OpenUniStream opens a Writer.
-func (cs *clientController) OpenUniStream() (io.WriteCloser, error) {
+func (cs *clientController) OpenUniStream() (WriteStream, error) {
 	return cs.conn.OpenUniStream()
 }
 
 // AcceptUniStream accepts a Reader.
-func (cs *clientController) AcceptUniStream(ctx context.Context) (io.Reader, error) {
+func (cs *clientController) AcceptUniStream(ctx context.Context) (ReadStream, error) {
 	return cs.conn.AcceptUniStream(ctx)
 }
 
@@ -106,7 +162,7 @@ func (cs *clientController) AcceptUniStream(ctx context.Context) (io.Reader, err
 func NewClientController(
 	ctx context.Context,
 	conn quic.Connection, stream0 quic.Stream,
-	frw *FrameReadWriter, logger *slog.Logger) *clientController {
+	frw *FrameReadWriter, name string, logger *slog.Logger) *clientController {
 
 	controlStream := &clientController{
 		ctx:     ctx,
@@ -114,6 +170,7 @@ func NewClientController(
 		stream0: stream0,
 		frw:     frw,
 		id:      "", // there is empty id if not being authenticated.
+		name:    name,
 		logger:  logger,
 	}
 
@@ -124,15 +181,43 @@ func (cs *clientController) readFrameLoop() {
 	for {
 		f, err := cs.frw.Readframe(cs.stream0)
 		if err != nil {
-			// TODO: new code!
-			cs.conn.CloseWithError(serverCloseCode, err.Error())
+			cs.setErr(asRejectedError(err))
+			cs.conn.CloseWithError(RejectCodeClosed.ApplicationErrorCode(), err.Error())
 			return
 		}
 		switch ff := f.(type) {
 		case *frame.RejectedFrame:
-			// TODO: adapt to quic code.
-			cs.conn.CloseWithError(quic.ApplicationErrorCode(ff.Code), ff.Message)
+			// the client is not allowed to publish or observe a tag or exceeds the limits of its tenant,
+			// the connection is still available.
+			code := RejectCode(ff.Code)
+			if code == RejectCodeForbidden || code == RejectCodeLimitExceeded {
+				cs.logger.Debug("control stream request is rejected", "code", code.String(), "message", ff.Message, "tag", ff.Tag)
+				if ff.Tag != "" {
+					cs.observeRejected(ff.Tag, &RejectedError{Code: code, Message: ff.Message})
+				}
+				continue
+			}
+			cs.setErr(&RejectedError{Code: code, Message: ff.Message})
+			cs.conn.CloseWithError(code.ApplicationErrorCode(), ff.Message)
 			return
+		case *frame.ReauthenticationFrame:
+			// answer in another goroutine, the challenges of the reauthentication are read by this loop.
+			go cs.reauthenticate(ff)
+		case *frame.AuthenticationChallengeFrame:
+			cs.mu.Lock()
+			cred := cs.cred
+			cs.mu.Unlock()
+			if err := cs.respond(cred, ff); err != nil {
+				cs.logger.Debug("failed to answer the reauthentication challenge", "err", err)
+			}
+		case *frame.AuthenticationAckFrame:
+			cs.logger.Debug("client is reauthenticated", "conn_id", ff.ID)
+		case *frame.StreamAckFrame:
+			var err *RejectedError
+			if code := RejectCode(ff.Code); code != RejectCodeClosed {
+				err = &RejectedError{Code: code}
+			}
+			cs.streamAcked(ff.ID, err)
 		default:
 			cs.logger.Debug("control stream read unexcepted frame", "frame_type", f.Type().String())
 		}
@@ -140,30 +225,53 @@ func (cs *clientController) readFrameLoop() {
 }
 
 // Authenticate sends the provided credential to the server's control stream to authenticate the client.
-// There will return `ErrAuthenticateFailed` if authenticate failed.
+// There will return a `*RejectedError` if the server rejects the client.
 func (cs *clientController) Authenticate(cred auth.Credential) error {
 	af := &frame.AuthenticationFrame{
 		AuthName:    cred.Name(),
 		AuthPayload: cred.Payload(),
+		ClientName:  cs.name,
 	}
-	if err := cs.frw.WriteFrame(cs.stream0, af); err != nil {
-		return err
+	if cs.handshakeTimeout > 0 {
+		if err := cs.stream0.SetDeadline(time.Now().Add(cs.handshakeTimeout)); err != nil {
+			return err
+		}
 	}
-	received, err := cs.frw.Readframe(cs.stream0)
-	if err != nil {
-		if qerr := new(quic.ApplicationError); errors.As(err, &qerr) && strings.HasPrefix(qerr.ErrorMessage, "authentication failed") {
-			return &ErrAuthenticateFailed{qerr.ErrorMessage}
+	if err := cs.writeFrame(af); err != nil {
+		return asRejectedError(err)
+	}
+	for {
+		received, err := cs.frw.Readframe(cs.stream0)
+		if err != nil {
+			return asRejectedError(err)
 		}
-		return err
+		switch f := received.(type) {
+		case *frame.AuthenticationChallengeFrame:
+			// multi-step authentication, answer the challenge and wait for the next frame.
+			if err := cs.respond(cred, f); err != nil {
+				return err
+			}
+			continue
+		case *frame.RejectedFrame:
+			return &RejectedError{Code: RejectCode(f.Code), Message: f.Message}
+		case *frame.AuthenticationAckFrame:
+			cs.id = f.ID
+			cs.mu.Lock()
+			cs.cred = cred
+			cs.mu.Unlock()
+		default:
+			return fmt.Errorf(
+				"yomo: read unexpected frame during waiting authentication resp, frame read: %s",
+				received.Type().String(),
+			)
+		}
+		break
 	}
-	f, ok := received.(*frame.AuthenticationAckFrame)
-	if !ok {
-		return fmt.Errorf(
-			"yomo: read unexpected frame during waiting authentication resp, frame read: %s",
-			received.Type().String(),
-		)
+
+	// the control stream lives as long as the connection once authenticated.
+	if err := cs.stream0.SetDeadline(time.Time{}); err != nil {
+		return err
 	}
-	cs.id = f.ID
 
 	// create a goroutinue to continuous read frame from server.
 	go cs.readFrameLoop()
@@ -171,16 +279,136 @@ func (cs *clientController) Authenticate(cred auth.Credential) error {
 	return nil
 }
 
+// respond answers the challenge from server with the credential.
+func (cs *clientController) respond(cred auth.Credential, challenge *frame.AuthenticationChallengeFrame) error {
+	cc, ok := cred.(auth.ChallengeCredential)
+	if !ok {
+		return fmt.Errorf("yomo: credential %s cannot answer the authentication challenge", cred.Name())
+	}
+	response, err := cc.Respond(challenge.Payload)
+	if err != nil {
+		return err
+	}
+	return cs.writeFrame(&frame.AuthenticationChallengeFrame{Payload: response})
+}
+
+// OnReauthenticate sets the function that returns fresh credential when server asks the client to reauthenticate.
+func (cs *clientController) OnReauthenticate(refresh func() (auth.Credential, error)) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.refresh = refresh
+}
+
+// OnObserveRejected sets the function called when the server rejects a request to observe a tag.
+func (cs *clientController) OnObserveRejected(fn func(tag string, err *RejectedError)) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.onObserveRejected = fn
+}
+
+func (cs *clientController) observeRejected(tag string, err *RejectedError) {
+	cs.mu.Lock()
+	fn := cs.onObserveRejected
+	cs.mu.Unlock()
+
+	if fn != nil {
+		fn(tag, err)
+	}
+}
+
+// OnStreamAcked sets the function called when the server acknowledges a stream opened.
+func (cs *clientController) OnStreamAcked(fn func(id string, err *RejectedError)) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.onStreamAcked = fn
+}
+
+func (cs *clientController) streamAcked(id string, err *RejectedError) {
+	cs.mu.Lock()
+	fn := cs.onStreamAcked
+	cs.mu.Unlock()
+
+	if fn != nil {
+		fn(id, err)
+	}
+}
+
+// reauthenticate answers the reauthentication of server with fresh credential,
+// The server closes the connection if the credential is not answered within the timeout of frame.
+func (cs *clientController) reauthenticate(f *frame.ReauthenticationFrame) {
+	cs.mu.Lock()
+	cred, refresh := cs.cred, cs.refresh
+	cs.mu.Unlock()
+
+	if refresh != nil {
+		fresh, err := refresh()
+		if err != nil {
+			cs.logger.Debug("failed to refresh credential for reauthentication", "err", err)
+			return
+		}
+		cred = fresh
+	}
+	if cred == nil {
+		cs.logger.Debug("no credential for reauthentication")
+		return
+	}
+
+	cs.mu.Lock()
+	cs.cred = cred
+	cs.mu.Unlock()
+
+	af := &frame.AuthenticationFrame{
+		AuthName:    cred.Name(),
+		AuthPayload: cred.Payload(),
+		ClientName:  cs.name,
+	}
+	if err := cs.writeFrame(af); err != nil {
+		cs.logger.Debug("failed to write reauthentication frame", "err", err)
+		return
+	}
+	cs.logger.Debug("client answers reauthentication", "timeout", time.Duration(f.Timeout)*time.Millisecond)
+}
+
 // Observe tells server that the client wants to observe specified tag.
 func (cs *clientController) Observe(tag string) error {
 	f := &frame.ObserveFrame{
 		Tag: tag,
 	}
-	return cs.frw.WriteFrame(cs.stream0, f)
+	return cs.writeFrame(f)
+}
+
+// Done returns a channel that is closed when the connection is closed.
+func (cs *clientController) Done() <-chan struct{} {
+	return cs.conn.Context().Done()
+}
+
+// Err returns the reason why the connection is closed.
+func (cs *clientController) Err() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return cs.err
+}
+
+func (cs *clientController) setErr(err error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.err == nil {
+		cs.err = err
+	}
+}
+
+// Close closes the client-side control stream.
+func (cs *clientController) Close() error {
+	return cs.CloseWithError("")
 }
 
 // CloseWithError closes the client-side control stream.
 func (cs *clientController) CloseWithError(errString string) error {
 	cs.stream0.Close()
-	return cs.conn.CloseWithError(serverCloseCode, errString)
+	return cs.conn.CloseWithError(RejectCodeClosed.ApplicationErrorCode(), errString)
 }
@@ -0,0 +1,326 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	mrand "math/rand"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/woorui/ydesign/core/auth"
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+	"golang.org/x/exp/slog"
+)
+
+// ALPN is the application protocol negotiated by TLS between client and server.
+const ALPN = "ydesign"
+
+const (
+	// DefaultDialTimeout is the default timeout of dialing the server.
+	DefaultDialTimeout = 10 * time.Second
+	// DefaultHandshakeTimeout is the default timeout of authenticating with the server.
+	DefaultHandshakeTimeout = 10 * time.Second
+	// DefaultReconnectInitialBackoff is the default wait before the first reconnection.
+	DefaultReconnectInitialBackoff = 500 * time.Millisecond
+	// DefaultReconnectMaxBackoff is the default upper bound of the wait between reconnections.
+	DefaultReconnectMaxBackoff = 30 * time.Second
+	// DefaultReconnectMultiplier is the default factor that the wait grows by after every failed reconnection.
+	DefaultReconnectMultiplier = 2
+	// DefaultReconnectJitter is the default fraction of the wait that is randomized.
+	DefaultReconnectJitter = 0.2
+	// NoReconnectJitter disables the jitter of reconnecting, the zero Jitter means DefaultReconnectJitter.
+	NoReconnectJitter = -1
+)
+
+// ClientOption is the option to create a client, It is created by `NewClientOption`
+// which fills the defaults and validates the option.
+type ClientOption struct {
+	// Codec encodes and decodes frames, It is `frame.NewCodec()` by default.
+	Codec frame.Codec
+	// PacketCodec reads and writes packets, It is `frame.NewPacketCodec(0)` by default.
+	PacketCodec frame.PacketCodec
+	// Logger is the logger of client, It is `slog.Default()` by default.
+	Logger *slog.Logger
+	// IDGenerator generates the ID of the streams opened, It generates random hex IDs by default.
+	IDGenerator func() string
+	// TLSConfig is the TLS config used to dial the server, `ALPN` is added to its NextProtos if it is empty.
+	TLSConfig *tls.Config
+	// QUICConfig is the QUIC config used to dial the server, It is the QUIC defaults if nil.
+	QUICConfig *quic.Config
+	// Credential authenticates the client, It is the credential without authentication by default.
+	Credential auth.Credential
+	// CredentialFunc returns a fresh credential for every authentication, including the reconnections and
+	// the reauthentications asked by server, for example, a renewed JWT or a timestamped HMAC token.
+	// Credential is used if it is nil.
+	CredentialFunc func() (auth.Credential, error)
+	// Name is the stable name of client presented to server, It is optional.
+	Name string
+	// DialTimeout limits the time of dialing the server.
+	DialTimeout time.Duration
+	// HandshakeTimeout limits the time of authenticating with the server.
+	HandshakeTimeout time.Duration
+	// InheritedMetadataKeys is the metadata keys that the streams opened in observers inherit from the observed stream,
+	// for example, the trace ID, tenant and correlation ID. The keys given to `Open` explicitly are not overwritten.
+	InheritedMetadataKeys []string
+	// MetadataLimits limits the metadata of the streams opened and observed,
+	// It is `metadata.DefaultLimits()` if nil, the zero Limits means no limit.
+	MetadataLimits *metadata.Limits
+	// MetadataSigningKey signs the metadata of the streams opened, The metadata is not signed if it is empty.
+	// The server verifies the signature with the `VerifyMetadataSignature` interceptor.
+	MetadataSigningKey []byte
+	// Reconnect makes the client reconnect when the connection is lost, It is disabled if nil.
+	Reconnect *ReconnectOption
+	// OnStateChange is called when the state of the connection changes, err is the reason of the change if any.
+	// It is only called if Reconnect is enabled, and it should not block.
+	OnStateChange func(state ConnState, err error)
+}
+
+// ReconnectOption is the option of reconnecting, The zero fields are filled with the defaults.
+type ReconnectOption struct {
+	// InitialBackoff is the wait before the first reconnection.
+	InitialBackoff time.Duration
+	// MaxBackoff is the upper bound of the wait between reconnections.
+	MaxBackoff time.Duration
+	// Multiplier is the factor that the wait grows by after every failed reconnection, It must be at least 1.
+	Multiplier float64
+	// Jitter is the fraction of the wait that is randomized, in [0, 1], It spreads the reconnections
+	// of many clients after the server restarts. Set it to `NoReconnectJitter` to wait exactly the backoff.
+	Jitter float64
+	// MaxAttempts is the number of the consecutive failed reconnections before giving up, 0 means no limit.
+	MaxAttempts int
+}
+
+// backoff returns the wait before the attempt-th reconnection, attempt starts from 0.
+func (o *ReconnectOption) backoff(attempt int) time.Duration {
+	d := float64(o.InitialBackoff) * math.Pow(o.Multiplier, float64(attempt))
+	if d > float64(o.MaxBackoff) {
+		d = float64(o.MaxBackoff)
+	}
+	if o.Jitter > 0 {
+		d += d * o.Jitter * (2*mrand.Float64() - 1)
+	}
+
+	return time.Duration(d)
+}
+
+// ClientOptionFunc sets a field of ClientOption.
+type ClientOptionFunc func(*ClientOption)
+
+// WithCodec sets the codec of frames.
+func WithCodec(codec frame.Codec) ClientOptionFunc {
+	return func(o *ClientOption) { o.Codec = codec }
+}
+
+// WithPacketCodec sets the codec of packets.
+func WithPacketCodec(packetCodec frame.PacketCodec) ClientOptionFunc {
+	return func(o *ClientOption) { o.PacketCodec = packetCodec }
+}
+
+// WithLogger sets the logger.
+func WithLogger(logger *slog.Logger) ClientOptionFunc {
+	return func(o *ClientOption) { o.Logger = logger }
+}
+
+// WithIDGenerator sets the generator of stream IDs.
+func WithIDGenerator(idGenerator func() string) ClientOptionFunc {
+	return func(o *ClientOption) { o.IDGenerator = idGenerator }
+}
+
+// WithTLSConfig sets the TLS config.
+func WithTLSConfig(tlsConfig *tls.Config) ClientOptionFunc {
+	return func(o *ClientOption) { o.TLSConfig = tlsConfig }
+}
+
+// WithQUICConfig sets the QUIC config.
+func WithQUICConfig(quicConfig *quic.Config) ClientOptionFunc {
+	return func(o *ClientOption) { o.QUICConfig = quicConfig }
+}
+
+// WithCredential sets the credential, the payload is in the form of `name:payload`, see `auth.NewCredential`.
+func WithCredential(payload string) ClientOptionFunc {
+	return func(o *ClientOption) { o.Credential = auth.NewCredential(payload) }
+}
+
+// WithAuthCredential sets the credential, use it for the credentials that answer challenges.
+func WithAuthCredential(cred auth.Credential) ClientOptionFunc {
+	return func(o *ClientOption) { o.Credential = cred }
+}
+
+// WithCredentialFunc sets the function that returns a fresh credential for every authentication.
+func WithCredentialFunc(fn func() (auth.Credential, error)) ClientOptionFunc {
+	return func(o *ClientOption) { o.CredentialFunc = fn }
+}
+
+// WithName sets the stable name of client.
+func WithName(name string) ClientOptionFunc {
+	return func(o *ClientOption) { o.Name = name }
+}
+
+// WithDialTimeout sets the timeout of dialing the server.
+func WithDialTimeout(timeout time.Duration) ClientOptionFunc {
+	return func(o *ClientOption) { o.DialTimeout = timeout }
+}
+
+// WithHandshakeTimeout sets the timeout of authenticating with the server.
+func WithHandshakeTimeout(timeout time.Duration) ClientOptionFunc {
+	return func(o *ClientOption) { o.HandshakeTimeout = timeout }
+}
+
+// WithInheritedMetadataKeys sets the metadata keys inherited by the streams opened in observers.
+func WithInheritedMetadataKeys(keys ...string) ClientOptionFunc {
+	return func(o *ClientOption) { o.InheritedMetadataKeys = keys }
+}
+
+// WithMetadataLimits sets the limits of metadata.
+func WithMetadataLimits(limits metadata.Limits) ClientOptionFunc {
+	return func(o *ClientOption) { o.MetadataLimits = &limits }
+}
+
+// WithMetadataSigningKey sets the key that signs the metadata of the streams opened.
+func WithMetadataSigningKey(key []byte) ClientOptionFunc {
+	return func(o *ClientOption) { o.MetadataSigningKey = key }
+}
+
+// WithReconnect enables reconnecting with the option, nil option uses the defaults.
+func WithReconnect(option *ReconnectOption) ClientOptionFunc {
+	return func(o *ClientOption) {
+		if option == nil {
+			option = &ReconnectOption{}
+		}
+		o.Reconnect = option
+	}
+}
+
+// WithStateHandler sets the function that is called when the state of the connection changes.
+func WithStateHandler(fn func(state ConnState, err error)) ClientOptionFunc {
+	return func(o *ClientOption) { o.OnStateChange = fn }
+}
+
+// NewClientOption returns the ClientOption with the opts applied and the defaults filled.
+func NewClientOption(opts ...ClientOptionFunc) (*ClientOption, error) {
+	o := &ClientOption{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return initOption(o)
+}
+
+// initOption fills the zero fields of o with the defaults and validates it, o is not modified.
+// It is idempotent, so the option initialized by NewClientOption can be passed to NewClient.
+func initOption(o *ClientOption) (*ClientOption, error) {
+	if o == nil {
+		o = &ClientOption{}
+	}
+	o2 := *o
+
+	if o2.Codec == nil {
+		o2.Codec = frame.NewCodec()
+	}
+	if o2.PacketCodec == nil {
+		o2.PacketCodec = frame.NewPacketCodec(0)
+	}
+	if o2.Logger == nil {
+		o2.Logger = slog.Default()
+	}
+	if o2.IDGenerator == nil {
+		o2.IDGenerator = randomID
+	}
+	if o2.TLSConfig == nil {
+		o2.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS13}
+	}
+	if len(o2.TLSConfig.NextProtos) == 0 {
+		o2.TLSConfig = o2.TLSConfig.Clone()
+		o2.TLSConfig.NextProtos = []string{ALPN}
+	}
+	if o2.Credential == nil {
+		o2.Credential = auth.NewCredential("")
+	}
+	if o2.DialTimeout == 0 {
+		o2.DialTimeout = DefaultDialTimeout
+	}
+	if o2.HandshakeTimeout == 0 {
+		o2.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if o2.MetadataLimits == nil {
+		limits := metadata.DefaultLimits()
+		o2.MetadataLimits = &limits
+	}
+	if o2.Reconnect != nil {
+		reconnect := *o2.Reconnect
+		if reconnect.InitialBackoff == 0 {
+			reconnect.InitialBackoff = DefaultReconnectInitialBackoff
+		}
+		if reconnect.MaxBackoff == 0 {
+			reconnect.MaxBackoff = DefaultReconnectMaxBackoff
+		}
+		if reconnect.Multiplier == 0 {
+			reconnect.Multiplier = DefaultReconnectMultiplier
+		}
+		// NoReconnectJitter is kept rather than turned into 0, so initializing the option again keeps it disabled.
+		if reconnect.Jitter == 0 {
+			reconnect.Jitter = DefaultReconnectJitter
+		}
+		o2.Reconnect = &reconnect
+	}
+
+	if err := o2.validate(); err != nil {
+		return nil, err
+	}
+
+	return &o2, nil
+}
+
+// validate validates the combination of the fields.
+func (o *ClientOption) validate() error {
+	if o.DialTimeout < 0 || o.HandshakeTimeout < 0 {
+		return errors.New("client option: timeouts cannot be negative")
+	}
+	if o.TLSConfig.MinVersion != 0 && o.TLSConfig.MinVersion < tls.VersionTLS13 {
+		return errors.New("client option: QUIC requires TLS 1.3")
+	}
+	if o.QUICConfig != nil && o.QUICConfig.HandshakeIdleTimeout > 0 && o.QUICConfig.HandshakeIdleTimeout > o.DialTimeout {
+		return fmt.Errorf("client option: QUIC handshake idle timeout %s exceeds the dial timeout %s",
+			o.QUICConfig.HandshakeIdleTimeout, o.DialTimeout)
+	}
+	if r := o.Reconnect; r != nil {
+		if r.InitialBackoff < 0 || r.MaxBackoff < r.InitialBackoff || r.MaxAttempts < 0 {
+			return errors.New("client option: invalid reconnect backoff")
+		}
+		if r.Multiplier < 1 || (r.Jitter < 0 && r.Jitter != NoReconnectJitter) || r.Jitter > 1 {
+			return errors.New("client option: reconnect multiplier must be at least 1 and jitter in [0, 1]")
+		}
+	}
+	if len(o.MetadataSigningKey) > 0 {
+		// the signature must fit in the limits, otherwise every stream opened exceeds them.
+		sig := metadata.MD{}
+		if err := sig.SignStream(o.MetadataSigningKey, "", ""); err != nil {
+			return err
+		}
+		if err := o.MetadataLimits.Check(sig); err != nil {
+			return fmt.Errorf("client option: metadata signature exceeds the metadata limits: %w", err)
+		}
+	}
+	return nil
+}
+
+// credential returns the credential of the next authentication.
+func (o *ClientOption) credential() (auth.Credential, error) {
+	if o.CredentialFunc != nil {
+		return o.CredentialFunc()
+	}
+	return o.Credential, nil
+}
+
+// randomID returns a random hex ID.
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
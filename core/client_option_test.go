@@ -0,0 +1,130 @@
+package core
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/metadata"
+	"golang.org/x/exp/slog"
+)
+
+func TestNewClientOption(t *testing.T) {
+	option, err := NewClientOption()
+	assert.NoError(t, err)
+
+	assert.NotNil(t, option.Codec)
+	assert.NotNil(t, option.PacketCodec)
+	assert.Equal(t, slog.Default(), option.Logger)
+	assert.Len(t, option.IDGenerator(), 32)
+	assert.Equal(t, uint16(tls.VersionTLS13), option.TLSConfig.MinVersion)
+	assert.Equal(t, []string{ALPN}, option.TLSConfig.NextProtos)
+	assert.Equal(t, "none", option.Credential.Name())
+	assert.Equal(t, DefaultDialTimeout, option.DialTimeout)
+	assert.Equal(t, DefaultHandshakeTimeout, option.HandshakeTimeout)
+	assert.Nil(t, option.Reconnect)
+	assert.Equal(t, metadata.DefaultLimits(), *option.MetadataLimits)
+
+	option, err = NewClientOption(WithReconnect(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, &ReconnectOption{
+		InitialBackoff: DefaultReconnectInitialBackoff,
+		MaxBackoff:     DefaultReconnectMaxBackoff,
+		Multiplier:     DefaultReconnectMultiplier,
+		Jitter:         DefaultReconnectJitter,
+	}, option.Reconnect)
+
+	// the jitter can be disabled, so the wait is exactly the backoff.
+	option, err = NewClientOption(WithReconnect(&ReconnectOption{InitialBackoff: time.Second, Jitter: NoReconnectJitter}))
+	assert.NoError(t, err)
+	assert.Equal(t, float64(NoReconnectJitter), option.Reconnect.Jitter)
+	for attempt := 0; attempt < 3; attempt++ {
+		assert.Equal(t, time.Second<<attempt, option.Reconnect.backoff(attempt))
+	}
+
+	// initializing the option again, as NewClient does, keeps the jitter disabled.
+	option, err = initOption(option)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(NoReconnectJitter), option.Reconnect.Jitter)
+}
+
+func TestInitOptionCopies(t *testing.T) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	reconnect := &ReconnectOption{}
+	option := &ClientOption{TLSConfig: tlsConfig, Reconnect: reconnect}
+
+	initialized, err := initOption(option)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ALPN}, initialized.TLSConfig.NextProtos)
+
+	// the option of the caller and its fields are not modified.
+	assert.Equal(t, &ClientOption{TLSConfig: tlsConfig, Reconnect: reconnect}, option)
+	assert.Empty(t, tlsConfig.NextProtos)
+	assert.Equal(t, &ReconnectOption{}, reconnect)
+}
+
+func TestClientOptionValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ClientOptionFunc
+		ok   bool
+	}{
+		{name: "defaults", ok: true},
+		{name: "negative dial timeout", opts: []ClientOptionFunc{func(o *ClientOption) { o.DialTimeout = -time.Second }}},
+		{name: "negative handshake timeout", opts: []ClientOptionFunc{func(o *ClientOption) { o.HandshakeTimeout = -time.Second }}},
+		{name: "TLS 1.2", opts: []ClientOptionFunc{WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})}},
+		{name: "TLS 1.3", opts: []ClientOptionFunc{WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS13})}, ok: true},
+		{name: "TLS version unset", opts: []ClientOptionFunc{WithTLSConfig(&tls.Config{})}, ok: true},
+		{
+			name: "QUIC idle timeout exceeds dial timeout",
+			opts: []ClientOptionFunc{
+				WithQUICConfig(&quic.Config{HandshakeIdleTimeout: 2 * time.Second}),
+				func(o *ClientOption) { o.DialTimeout = time.Second },
+			},
+		},
+		{
+			name: "QUIC idle timeout within dial timeout",
+			opts: []ClientOptionFunc{
+				WithQUICConfig(&quic.Config{HandshakeIdleTimeout: time.Second}),
+				func(o *ClientOption) { o.DialTimeout = time.Second },
+			},
+			ok: true,
+		},
+		{name: "negative initial backoff", opts: []ClientOptionFunc{WithReconnect(&ReconnectOption{InitialBackoff: -time.Second})}},
+		{name: "max backoff below initial", opts: []ClientOptionFunc{WithReconnect(&ReconnectOption{InitialBackoff: time.Minute, MaxBackoff: time.Second})}},
+		{name: "negative max attempts", opts: []ClientOptionFunc{WithReconnect(&ReconnectOption{MaxAttempts: -1})}},
+		{name: "multiplier below 1", opts: []ClientOptionFunc{WithReconnect(&ReconnectOption{Multiplier: 0.5})}},
+		{name: "negative jitter", opts: []ClientOptionFunc{WithReconnect(&ReconnectOption{Jitter: -0.1})}},
+		{name: "jitter above 1", opts: []ClientOptionFunc{WithReconnect(&ReconnectOption{Jitter: 1.5})}},
+		{
+			name: "signing key within limits",
+			opts: []ClientOptionFunc{WithMetadataSigningKey([]byte("secret")), WithMetadataLimits(metadata.Limits{MaxKeys: 1})},
+			ok:   true,
+		},
+		{
+			name: "signature exceeds size limit",
+			opts: []ClientOptionFunc{WithMetadataSigningKey([]byte("secret")), WithMetadataLimits(metadata.Limits{MaxSize: 8})},
+		},
+		{
+			name: "signature exceeds value length",
+			opts: []ClientOptionFunc{WithMetadataSigningKey([]byte("secret")), WithMetadataLimits(metadata.Limits{MaxValueLength: 32})},
+		},
+		{
+			name: "limits without signing key",
+			opts: []ClientOptionFunc{WithMetadataLimits(metadata.Limits{MaxValueLength: 32})},
+			ok:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClientOption(tt.opts...)
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package core
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/acl"
+	"github.com/woorui/ydesign/core/auth"
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+	"golang.org/x/exp/slog"
+)
+
+func TestOpenClient(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	registry := auth.NewRegistry()
+	registry.Register(auth.NewTokenAuth(auth.Token{Token: "secret", Metadata: metadata.MD{"role": {"ingest"}}}))
+
+	addr := listenServer(t, ctx, &ServerOption{Auth: registry})
+
+	// the credential is rejected.
+	_, err := OpenClient(ctx, addr, WithTLSConfig(insecureTLSConfig()), WithCredential("token:wrong"))
+	assert.ErrorIs(t, err, RejectCodeAuthFailed)
+
+	observer, err := OpenClient(ctx, addr, WithTLSConfig(insecureTLSConfig()), WithCredential("token:secret"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, observer.ID())
+	defer observer.Close()
+
+	writer, err := OpenClient(ctx, addr, WithTLSConfig(insecureTLSConfig()), WithCredential("token:secret"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, writer.ID())
+	assert.NotEqual(t, observer.ID(), writer.ID())
+	defer writer.Close()
+
+	type received struct {
+		header *StreamHeader
+		data   []byte
+	}
+	receivedCh := make(chan received, 1)
+	go observer.Observe("sensors", ObserveHandleFunc(func(_ WriterOpener, header *StreamHeader, r ReadStream) {
+		data, _ := io.ReadAll(r)
+		receivedCh <- received{header: header, data: data}
+	}))
+
+	w, err := writer.Open("sensors", metadata.MD{"unit": {"celsius"}})
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("21.5"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	select {
+	case r := <-receivedCh:
+		assert.Equal(t, "sensors", r.header.Tag)
+		assert.Equal(t, metadata.MD{"unit": {"celsius"}}, r.header.Metadata)
+		assert.Equal(t, []byte("21.5"), r.data)
+	case <-ctx.Done():
+		t.Fatal("the stream is not observed")
+	}
+}
+
+func TestObserveRejected(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rules, err := acl.Parse("* may observe sensors", "* may publish sensors")
+	assert.NoError(t, err)
+	addr := listenServer(t, ctx, &ServerOption{ACL: rules})
+	client := openTestClient(t, ctx, addr)
+
+	// the forbidden tag is returned to the caller of Observe, and the client is still available.
+	_, errCh := observe(client, "secret")
+	select {
+	case err := <-errCh:
+		var rerr *RejectedError
+		assert.ErrorAs(t, err, &rerr)
+		assert.Equal(t, RejectCodeForbidden, rerr.Code)
+		assert.Contains(t, rerr.Message, "secret")
+	case <-ctx.Done():
+		t.Fatal("the forbidden observing is not returned")
+	}
+
+	streams, errCh := observe(client, "sensors")
+	w, err := openTestClient(t, ctx, addr).Open("sensors", nil)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("21.5"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.Equal(t, "21.5", string(receive(t, streams).data))
+	assert.Empty(t, errCh)
+}
+
+func TestObserveTags(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := listenServer(t, ctx, nil)
+	client := openTestClient(t, ctx, addr)
+
+	// the streams are dispatched to the observers by their tags.
+	sensors, _ := observe(client, "sensors")
+	logs, _ := observe(client, "logs")
+
+	writer := openTestClient(t, ctx, addr)
+	for _, tag := range []string{"logs", "sensors"} {
+		w, err := writer.Open(tag, nil)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(tag))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+	}
+
+	o := receive(t, sensors)
+	assert.Equal(t, "sensors", o.header.Tag)
+	assert.Equal(t, "sensors", string(o.data))
+	o = receive(t, logs)
+	assert.Equal(t, "logs", o.header.Tag)
+	assert.Equal(t, "logs", string(o.data))
+
+	// a tag is observed by one observer at a time.
+	assert.Eventually(t, func() bool {
+		_, ok := client.observer("logs")
+		return ok
+	}, time.Second, time.Millisecond)
+	assert.Error(t, client.Observe("logs", ObserveHandleFunc(func(WriterOpener, *StreamHeader, ReadStream) {})))
+}
+
+// listenServer starts a server on a random local port and returns its address,
+// The server allows anonymous clients if option has no Auth.
+func listenServer(t *testing.T, ctx context.Context, option *ServerOption) string {
+	return listenServerAt(t, ctx, "127.0.0.1:0", option)
+}
+
+// listenServerAt is listenServer on the UDP address addr, It retries until addr is released by the closed servers.
+func listenServerAt(t *testing.T, ctx context.Context, addr string, option *ServerOption) string {
+	_, addr = startServer(t, ctx, addr, option)
+	return addr
+}
+
+// startServer is listenServerAt that returns the server too.
+func startServer(t *testing.T, ctx context.Context, addr string, option *ServerOption) (*Server, string) {
+	if option == nil {
+		option = &ServerOption{}
+	}
+	if option.Auth == nil {
+		option.Auth = auth.NewRegistry()
+		option.Auth.AllowAnonymous(metadata.MD{"role": {"anonymous"}})
+	}
+	frw := NewFrameReadWriter(frame.NewPacketCodec(0), frame.NewCodec())
+	server := NewServer(ctx, frw, slog.Default(), option)
+	t.Cleanup(func() { server.Close() })
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t)},
+		NextProtos:   []string{ALPN},
+	}
+	var (
+		ln  *quic.Listener
+		err error
+	)
+	assert.Eventually(t, func() bool {
+		ln, err = quic.ListenAddr(addr, tlsConfig, nil)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	go server.Serve(ln)
+
+	return server, ln.Addr().String()
+}
+
+func TestInheritMetadata(t *testing.T) {
+	incoming := metadata.MD{"trace_id": {"t1"}, "zone": {"a", "b"}, "secret": {"x"}}
+
+	tests := []struct {
+		name     string
+		md       metadata.MD
+		incoming metadata.MD
+		keys     []string
+		want     metadata.MD
+	}{
+		{name: "no keys", md: metadata.MD{"stage": {"1"}}, incoming: incoming, want: metadata.MD{"stage": {"1"}}},
+		{name: "no incoming", md: metadata.MD{"stage": {"1"}}, keys: []string{"trace_id"}, want: metadata.MD{"stage": {"1"}}},
+		{name: "nil md", incoming: incoming, keys: []string{"trace_id"}, want: metadata.MD{"trace_id": {"t1"}}},
+		{
+			name: "allowed keys only", md: metadata.MD{"stage": {"1"}}, incoming: incoming, keys: []string{"trace_id", "zone", "missing"},
+			want: metadata.MD{"stage": {"1"}, "trace_id": {"t1"}, "zone": {"a", "b"}},
+		},
+		{
+			name: "own keys are kept", md: metadata.MD{"trace_id": {"t2"}}, incoming: incoming, keys: []string{"trace_id"},
+			want: metadata.MD{"trace_id": {"t2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, inheritMetadata(tt.md, tt.incoming, tt.keys))
+		})
+	}
+
+	// the values are copied, so modifying the result does not modify the observed stream.
+	md := inheritMetadata(nil, incoming, []string{"zone"})
+	md["zone"][0] = "c"
+	assert.Equal(t, []string{"a", "b"}, incoming.Values("zone"))
+}
+
+func TestObservedOpenerContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{ctx: ctx, ctxCancel: cancel}
+
+	var (
+		contexts = make(chan context.Context)
+		done     = make(chan struct{})
+	)
+	handle := func(block bool) {
+		c.handle(ObserveHandleFunc(func(opener WriterOpener, _ *StreamHeader, _ ReadStream) {
+			contexts <- opener.Context()
+			if block {
+				<-opener.Context().Done()
+			}
+		}), &StreamHeader{Metadata: metadata.MD{"trace_id": {"t1"}}}, nil)
+		done <- struct{}{}
+	}
+
+	// the context carries the metadata of the stream, and it is canceled when the observer returns.
+	go handle(false)
+	streamCtx := <-contexts
+	md, ok := metadata.FromContext(streamCtx)
+	assert.True(t, ok)
+	assert.Equal(t, metadata.MD{"trace_id": {"t1"}}, md)
+	<-done
+	assert.ErrorIs(t, streamCtx.Err(), context.Canceled)
+
+	// the context is canceled when the client is closed.
+	go handle(true)
+	streamCtx = <-contexts
+	assert.NoError(t, streamCtx.Err())
+	c.ctxCancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the context is not canceled when the client is closed")
+	}
+}
+
+func TestObservedOpenerOpen(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := listenServer(t, ctx, nil)
+
+	processor := openTestClient(t, ctx, addr, WithInheritedMetadataKeys("trace_id"))
+	go processor.Observe("raw", ObserveHandleFunc(func(opener WriterOpener, header *StreamHeader, r ReadStream) {
+		data, _ := io.ReadAll(r)
+		w, err := opener.Open("processed", metadata.MD{"stage": {"1"}})
+		if err != nil {
+			return
+		}
+		w.Write(data)
+		w.Close()
+	}))
+	streams, _ := observe(openTestClient(t, ctx, addr), "processed")
+
+	w, err := openTestClient(t, ctx, addr).Open("raw", metadata.MD{"trace_id": {"t1"}, "secret": {"x"}})
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("21.5"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	// the stream opened by the observer inherits the allowed keys only.
+	o := receive(t, streams)
+	assert.Equal(t, "21.5", string(o.data))
+	assert.Equal(t, []string{"t1"}, o.header.Metadata.Values("trace_id"))
+	assert.Equal(t, []string{"1"}, o.header.Metadata.Values("stage"))
+	assert.Empty(t, o.header.Metadata.Values("secret"))
+}
+
+// openTestClient opens an authenticated client to the server at addr, it is closed when the test ends.
+func openTestClient(t *testing.T, ctx context.Context, addr string, opts ...ClientOptionFunc) *Client {
+	opts = append([]ClientOptionFunc{WithTLSConfig(insecureTLSConfig())}, opts...)
+	client, err := OpenClient(ctx, addr, opts...)
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// observed is a stream received by the observer of observe.
+type observed struct {
+	header *StreamHeader
+	data   []byte
+	err    error
+}
+
+// observe observes the tag with c in background, It returns the streams received and the error of Observe.
+func observe(c *Client, tag string) (<-chan observed, <-chan error) {
+	var (
+		streams = make(chan observed, 16)
+		errCh   = make(chan error, 1)
+	)
+	go func() {
+		errCh <- c.Observe(tag, ObserveHandleFunc(func(_ WriterOpener, header *StreamHeader, r ReadStream) {
+			data, err := io.ReadAll(r)
+			streams <- observed{header: header, data: data, err: err}
+		}))
+	}()
+	return streams, errCh
+}
+
+// receive returns the next stream of ch, It fails the test if no stream is received in time.
+func receive(t *testing.T, ch <-chan observed) observed {
+	t.Helper()
+
+	select {
+	case o := <-ch:
+		return o
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream is not observed")
+		return observed{}
+	}
+}
+
+func insecureTLSConfig() *tls.Config {
+	return &tls.Config{InsecureSkipVerify: true, NextProtos: []string{ALPN}}
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,189 @@
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// DefaultMaxPacketSize is the max size of a packet read by the PacketCodec returned by NewPacketCodec.
+const DefaultMaxPacketSize = 16 << 20
+
+var (
+	// ErrPacketTooLarge is returned if the packet read exceeds the max size of PacketCodec.
+	ErrPacketTooLarge = errors.New("frame: packet exceeds the max size")
+	errTruncatedFrame = errors.New("frame: truncated frame")
+)
+
+// NewPacketCodec returns the default PacketCodec. A packet is the frame type, the length of data in uvarint and the data,
+// The packet larger than maxSize is refused before it is read, maxSize is `DefaultMaxPacketSize` if it is not positive.
+func NewPacketCodec(maxSize int) PacketCodec {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxPacketSize
+	}
+	return &packetCodec{maxSize: maxSize}
+}
+
+type packetCodec struct {
+	maxSize int
+}
+
+// WithMaxSize returns a copy of c that refuses the packet larger than maxSize, c.maxSize is kept if it is smaller.
+func (c *packetCodec) WithMaxSize(maxSize int) PacketCodec {
+	if maxSize <= 0 || maxSize > c.maxSize {
+		maxSize = c.maxSize
+	}
+	return &packetCodec{maxSize: maxSize}
+}
+
+// ReadPacket reads raw frame from Reader.
+func (c *packetCodec) ReadPacket(r io.Reader) (Type, []byte, error) {
+	br := byteReader{r}
+
+	t, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	if n > uint64(c.maxSize) {
+		return 0, nil, fmt.Errorf("%w: %d bytes, the max size is %d", ErrPacketTooLarge, n, c.maxSize)
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+
+	return Type(t), data, nil
+}
+
+// WritePacket writes raw frame from Writer.
+func (c *packetCodec) WritePacket(w io.Writer, t Type, data []byte) error {
+	buf := make([]byte, 0, 1+binary.MaxVarintLen64+len(data))
+	buf = append(buf, byte(t))
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	buf = append(buf, data...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// byteReader reads one byte at a time without buffering, so no byte of the next packet is consumed.
+type byteReader struct {
+	r io.Reader
+}
+
+func (br byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(br.r, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+var _ io.ByteReader = byteReader{}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF, the packet is incomplete if it ends in the middle.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// NewCodec returns the default Codec. It encodes the fields of frame in order,
+// The strings and byte slices are prefixed with their length, and the integers are uvarint.
+func NewCodec() Codec {
+	return codec{}
+}
+
+type codec struct{}
+
+// Encode encodes frame to byte array.
+func (codec) Encode(f Frame) ([]byte, error) {
+	v, err := frameValue(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			buf = binary.AppendUvarint(buf, uint64(field.Len()))
+			buf = append(buf, field.String()...)
+		case reflect.Uint64:
+			buf = binary.AppendUvarint(buf, field.Uint())
+		case reflect.Slice:
+			buf = binary.AppendUvarint(buf, uint64(field.Len()))
+			buf = append(buf, field.Bytes()...)
+		}
+	}
+
+	return buf, nil
+}
+
+// Decode decodes byte array to frame.
+func (codec) Decode(data []byte, f Frame) error {
+	v, err := frameValue(f)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		n, size := binary.Uvarint(data)
+		if size <= 0 {
+			return errTruncatedFrame
+		}
+		data = data[size:]
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Uint64 {
+			field.SetUint(n)
+			continue
+		}
+		if n > uint64(len(data)) {
+			return errTruncatedFrame
+		}
+		if field.Kind() == reflect.String {
+			field.SetString(string(data[:n]))
+		} else {
+			field.SetBytes(append([]byte(nil), data[:n]...))
+		}
+		data = data[n:]
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("frame: %d trailing bytes after %s", len(data), f.Type().String())
+	}
+
+	return nil
+}
+
+// frameValue returns the struct value of f, It checks that the fields of f can be encoded by codec.
+func frameValue(f Frame) (reflect.Value, error) {
+	v := reflect.ValueOf(f)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("frame: cannot encode %T", f)
+	}
+	v = v.Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		switch field := v.Field(i); field.Kind() {
+		case reflect.String, reflect.Uint64:
+		case reflect.Slice:
+			if field.Type().Elem().Kind() != reflect.Uint8 {
+				return reflect.Value{}, fmt.Errorf("frame: cannot encode field %s of %T", v.Type().Field(i).Name, f)
+			}
+		default:
+			return reflect.Value{}, fmt.Errorf("frame: cannot encode field %s of %T", v.Type().Field(i).Name, f)
+		}
+	}
+
+	return v, nil
+}
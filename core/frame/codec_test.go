@@ -0,0 +1,69 @@
+package frame
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodec(t *testing.T) {
+	var (
+		buf         = new(bytes.Buffer)
+		codec       = NewCodec()
+		packetCodec = NewPacketCodec(0)
+	)
+
+	frames := []Frame{
+		&AuthenticationFrame{AuthName: "token", AuthPayload: "a:b\nc", ClientName: "device-1"},
+		&AuthenticationAckFrame{ID: "id"},
+		&AuthenticationChallengeFrame{},
+		&ReauthenticationFrame{Timeout: 1500},
+		&ObserveFrame{Tag: "sensors"},
+		&OpenStreamFrame{ID: "1", Tag: "sensors", Metadata: []byte{0x00, 0x01, 0x00}},
+		&RejectedFrame{Code: 225, Message: "forbidden", Tag: "sensors"},
+		&StreamAckFrame{ID: "1", Code: 225},
+	}
+	for _, f := range frames {
+		data, err := codec.Encode(f)
+		assert.NoError(t, err)
+		assert.NoError(t, packetCodec.WritePacket(buf, f.Type(), data))
+	}
+
+	for _, want := range frames {
+		ft, data, err := packetCodec.ReadPacket(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, want.Type(), ft)
+
+		f, err := NewFrame(ft)
+		assert.NoError(t, err)
+		assert.NoError(t, codec.Decode(data, f))
+		assert.Equal(t, want, f)
+	}
+
+	_, _, err := packetCodec.ReadPacket(buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestCodecErrors(t *testing.T) {
+	codec := NewCodec()
+
+	data, err := codec.Encode(&ObserveFrame{Tag: "sensors"})
+	assert.NoError(t, err)
+	assert.Error(t, codec.Decode(data[:3], new(ObserveFrame)))
+	assert.Error(t, codec.Decode(append(data, 0x00), new(ObserveFrame)))
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, NewPacketCodec(0).WritePacket(buf, TypeObserveFrame, make([]byte, 10)))
+	_, _, err = NewPacketCodec(9).ReadPacket(bytes.NewReader(buf.Bytes()))
+	assert.ErrorIs(t, err, ErrPacketTooLarge)
+	_, _, err = NewPacketCodec(0).ReadPacket(bytes.NewReader(buf.Bytes()[:5]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// the max size can be lowered but not raised.
+	_, _, err = NewPacketCodec(0).(PacketSizeLimiter).WithMaxSize(9).ReadPacket(bytes.NewReader(buf.Bytes()))
+	assert.ErrorIs(t, err, ErrPacketTooLarge)
+	_, _, err = NewPacketCodec(9).(PacketSizeLimiter).WithMaxSize(10).ReadPacket(bytes.NewReader(buf.Bytes()))
+	assert.ErrorIs(t, err, ErrPacketTooLarge)
+}
@@ -24,6 +24,13 @@ type PacketCodec interface {
 	WritePacket(io.Writer, Type, []byte) error
 }
 
+// PacketSizeLimiter is implemented by the PacketCodec whose max packet size can be lowered.
+type PacketSizeLimiter interface {
+	// WithMaxSize returns a copy of the PacketCodec that refuses the packet larger than maxSize before it is read,
+	// The max size of the PacketCodec is kept if it is smaller.
+	WithMaxSize(maxSize int) PacketCodec
+}
+
 // Codec encodes and decodes byte array (the raw frame data) to frame.
 type Codec interface {
 	// Decode decodes byte array to frame.
@@ -43,6 +50,9 @@ type AuthenticationFrame struct {
 	AuthName string
 	// AuthPayload.
 	AuthPayload string
+	// ClientName is the stable name of client, It is optional.
+	// Unlike the ID assigned by server, it is the same after client reconnects.
+	ClientName string
 }
 
 // Type returns the type of AuthenticationFrame.
@@ -59,6 +69,28 @@ type AuthenticationAckFrame struct {
 // Type returns the type of AuthenticationAckFrame.
 func (f *AuthenticationAckFrame) Type() Type { return TypeAuthenticationAckFrame }
 
+// AuthenticationChallengeFrame is used by multi-step authentication, It is transmit on ControlStream in both directions.
+// The server sends it to challenge the client during authentication, and the client answers the challenge with it.
+// The authentication finishes when the server sends AuthenticationAckFrame or RejectedFrame.
+type AuthenticationChallengeFrame struct {
+	// Payload is the challenge from server or the response from client.
+	Payload string
+}
+
+// Type returns the type of AuthenticationChallengeFrame.
+func (f *AuthenticationChallengeFrame) Type() Type { return TypeAuthenticationChallengeFrame }
+
+// ReauthenticationFrame is used by server to ask the client to present fresh credentials, It is transmit on ControlStream.
+// The client answers it with AuthenticationFrame within the timeout, and the server answers the AuthenticationFrame
+// like the first authentication, the connection will be closed if the client fails or does not answer in time.
+type ReauthenticationFrame struct {
+	// Timeout is the milliseconds that the client has to present fresh credentials.
+	Timeout uint64
+}
+
+// Type returns the type of ReauthenticationFrame.
+func (f *ReauthenticationFrame) Type() Type { return TypeReauthenticationFrame }
+
 // ObserveFrame is used to open a new peer stream and make connection observe a peer stream.
 // Each peer stream has a tag that identifies which connection can observe it.
 type ObserveFrame struct {
@@ -89,25 +121,46 @@ type RejectedFrame struct {
 	Code uint64
 	// Message contains the reason why the reqeust be rejected.
 	Message string
+	// Tag is the tag of the ObserveFrame rejected, It is empty if the rejection is not of an observe request.
+	Tag string
 }
 
 // Type returns the type of RejectedFrame.
 func (f *RejectedFrame) Type() Type { return TypeRejectedFrame }
 
+// StreamAckFrame tells the writer of a stream whether the stream is delivered, It is transmit on ControlStream.
+// The server sends it after the stream is forwarded to an observer completely or the stream is canceled,
+// the stream parked for an observer is not acknowledged until it is forwarded.
+type StreamAckFrame struct {
+	// ID is the ID of the stream header.
+	ID string
+	// Code is 0 if the stream is delivered, otherwise it is the code that the stream is canceled with.
+	Code uint64
+}
+
+// Type returns the type of StreamAckFrame.
+func (f *StreamAckFrame) Type() Type { return TypeStreamAckFrame }
+
 const (
-	TypeAuthenticationFrame    Type = 0x03 // TypeAuthenticationFrame is the type of AuthenticationFrame.
-	TypeAuthenticationAckFrame Type = 0x11 // TypeAuthenticationAckFrame is the type of AuthenticationAckFrame.
-	TypeRejectedFrame          Type = 0x39 // TypeRejectedFrame is the type of RejectedFrame.
-	TypeObserveFrame           Type = 0x2F // TypeObserveFrame is the type of ObserveFrame.
-	TypeOpenStreamFrame        Type = 0x30 // TypeOpenStreamFrame is the type of OpenStreamFrame
+	TypeAuthenticationFrame          Type = 0x03 // TypeAuthenticationFrame is the type of AuthenticationFrame.
+	TypeAuthenticationAckFrame       Type = 0x11 // TypeAuthenticationAckFrame is the type of AuthenticationAckFrame.
+	TypeAuthenticationChallengeFrame Type = 0x12 // TypeAuthenticationChallengeFrame is the type of AuthenticationChallengeFrame.
+	TypeReauthenticationFrame        Type = 0x13 // TypeReauthenticationFrame is the type of ReauthenticationFrame.
+	TypeRejectedFrame                Type = 0x39 // TypeRejectedFrame is the type of RejectedFrame.
+	TypeObserveFrame                 Type = 0x2F // TypeObserveFrame is the type of ObserveFrame.
+	TypeOpenStreamFrame              Type = 0x30 // TypeOpenStreamFrame is the type of OpenStreamFrame
+	TypeStreamAckFrame               Type = 0x31 // TypeStreamAckFrame is the type of StreamAckFrame.
 )
 
 var frameTypeStringMap = map[Type]string{
-	TypeAuthenticationFrame:    "AuthenticationFrame",
-	TypeAuthenticationAckFrame: "AuthenticationAckFrame",
-	TypeRejectedFrame:          "RejectedFrame",
-	TypeObserveFrame:           "ObserveFrame",
-	TypeOpenStreamFrame:        "OpenStreamFrame",
+	TypeAuthenticationFrame:          "AuthenticationFrame",
+	TypeAuthenticationAckFrame:       "AuthenticationAckFrame",
+	TypeAuthenticationChallengeFrame: "AuthenticationChallengeFrame",
+	TypeReauthenticationFrame:        "ReauthenticationFrame",
+	TypeRejectedFrame:                "RejectedFrame",
+	TypeObserveFrame:                 "ObserveFrame",
+	TypeOpenStreamFrame:              "OpenStreamFrame",
+	TypeStreamAckFrame:               "StreamAckFrame",
 }
 
 // String returns a human-readable string which represents the frame type.
@@ -121,11 +174,14 @@ func (f Type) String() string {
 }
 
 var frameTypeNewFuncMap = map[Type]func() Frame{
-	TypeAuthenticationFrame:    func() Frame { return new(AuthenticationFrame) },
-	TypeAuthenticationAckFrame: func() Frame { return new(AuthenticationAckFrame) },
-	TypeObserveFrame:           func() Frame { return new(ObserveFrame) },
-	TypeOpenStreamFrame:        func() Frame { return new(OpenStreamFrame) },
-	TypeRejectedFrame:          func() Frame { return new(RejectedFrame) },
+	TypeAuthenticationFrame:          func() Frame { return new(AuthenticationFrame) },
+	TypeAuthenticationAckFrame:       func() Frame { return new(AuthenticationAckFrame) },
+	TypeAuthenticationChallengeFrame: func() Frame { return new(AuthenticationChallengeFrame) },
+	TypeReauthenticationFrame:        func() Frame { return new(ReauthenticationFrame) },
+	TypeObserveFrame:                 func() Frame { return new(ObserveFrame) },
+	TypeOpenStreamFrame:              func() Frame { return new(OpenStreamFrame) },
+	TypeRejectedFrame:                func() Frame { return new(RejectedFrame) },
+	TypeStreamAckFrame:               func() Frame { return new(StreamAckFrame) },
 }
 
 // NewFrame creates a new frame from Type.
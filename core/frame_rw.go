@@ -4,11 +4,18 @@ import (
 	"io"
 
 	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
 )
 
+// maxFrameOverhead is the room for the fields of a frame besides the metadata, such as the ID and tag of
+// a stream header and the payload of authentication.
+const maxFrameOverhead = 16 << 10
+
 type FrameReadWriter struct {
 	codec       frame.Codec
 	packetCodec frame.PacketCodec
+	// metadataLimits limits the metadata of the stream headers read and written.
+	metadataLimits metadata.Limits
 }
 
 func NewFrameReadWriter(packetCodec frame.PacketCodec, codec frame.Codec) *FrameReadWriter {
@@ -18,6 +25,18 @@ func NewFrameReadWriter(packetCodec frame.PacketCodec, codec frame.Codec) *Frame
 	}
 }
 
+// WithMetadataLimits returns a copy of rw that limits the metadata of the stream headers it reads and writes.
+// The frames read are limited to `limits.MaxSize` plus the room for the other fields if the packet codec
+// implements frame.PacketSizeLimiter, so a huge frame is refused before it is read.
+func (rw *FrameReadWriter) WithMetadataLimits(limits metadata.Limits) *FrameReadWriter {
+	rw2 := *rw
+	rw2.metadataLimits = limits
+	if l, ok := rw.packetCodec.(frame.PacketSizeLimiter); ok && limits.MaxSize > 0 {
+		rw2.packetCodec = l.WithMaxSize(limits.MaxSize + maxFrameOverhead)
+	}
+	return &rw2
+}
+
 func (rw *FrameReadWriter) Readframe(r io.Reader) (frame.Frame, error) {
 	ft, raw, err := rw.packetCodec.ReadPacket(r)
 	if err != nil {
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// HandshakeOption limits the authentication handshake of clients,
+// It protects the server from the connections that never authenticate or guess credentials.
+type HandshakeOption struct {
+	// Timeout is the time that the client has to complete the authentication, 0 means no limit.
+	Timeout time.Duration
+	// MaxPending limits the number of connections that are authenticating at the same time, 0 means no limit.
+	MaxPending int
+	// MaxFailures limits the number of failed authentications per source address within FailureWindow,
+	// The address is rejected without authentication once it exceeds the limit, 0 means no limit.
+	MaxFailures int
+	// FailureWindow is the window of MaxFailures, It is one minute if zero.
+	FailureWindow time.Duration
+}
+
+const defaultFailureWindow = time.Minute
+
+// handshake limits the authentication handshake of clients.
+type handshake struct {
+	option *HandshakeOption
+	// pending is a semaphore of the connections that are authenticating.
+	pending chan struct{}
+
+	mu       sync.Mutex
+	failures map[string]*handshakeFailures
+}
+
+type handshakeFailures struct {
+	count int
+	since time.Time
+}
+
+// newHandshake returns the handshake limited by option, It returns nil if option is nil,
+// which means no limits. The option is copied, so the caller can reuse it.
+func newHandshake(option *HandshakeOption) *handshake {
+	if option == nil {
+		return nil
+	}
+	copied := *option
+	if copied.FailureWindow <= 0 {
+		copied.FailureWindow = defaultFailureWindow
+	}
+	h := &handshake{
+		option:   &copied,
+		failures: make(map[string]*handshakeFailures),
+	}
+	if copied.MaxPending > 0 {
+		h.pending = make(chan struct{}, copied.MaxPending)
+	}
+	return h
+}
+
+// acquire takes a pending slot, It returns false if there are too many connections authenticating.
+func (h *handshake) acquire() bool {
+	if h == nil || h.pending == nil {
+		return true
+	}
+	select {
+	case h.pending <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release gives back the pending slot taken by acquire.
+func (h *handshake) release() {
+	if h == nil || h.pending == nil {
+		return
+	}
+	<-h.pending
+}
+
+// blocked reports whether the addr fails the authentication too many times.
+func (h *handshake) blocked(addr string) bool {
+	if h == nil || h.option.MaxFailures <= 0 {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, ok := h.failures[addr]
+	if !ok {
+		return false
+	}
+	if time.Since(f.since) > h.option.FailureWindow {
+		delete(h.failures, addr)
+		return false
+	}
+	return f.count >= h.option.MaxFailures
+}
+
+// fail records a failed authentication of addr.
+func (h *handshake) fail(addr string) {
+	if h == nil || h.option.MaxFailures <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	// drop the expired records so that the map does not grow with the addresses forever.
+	for k, f := range h.failures {
+		if now.Sub(f.since) > h.option.FailureWindow {
+			delete(h.failures, k)
+		}
+	}
+
+	f, ok := h.failures[addr]
+	if !ok {
+		f = &handshakeFailures{since: now}
+		h.failures[addr] = f
+	}
+	f.count++
+}
+
+// deadline returns the deadline of the handshake that starts now, zero means no deadline.
+func (h *handshake) deadline() time.Time {
+	if h == nil || h.option.Timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(h.option.Timeout)
+}
+
+// authenticate accepts the control stream of conn and authenticates the client within the limits of handshake,
+// It returns the controller of the authenticated client, the client is rejected and disconnected if it returns an error.
+//
+// The pending slot is taken before the control stream is accepted, so the connections that never open it
+// count against MaxPending as well.
+func (s *Server) authenticate(conn quic.Connection) (*ServerController, error) {
+	h := s.handshake
+	addr := remoteHost(conn.RemoteAddr())
+
+	if h.blocked(addr) {
+		errString := fmt.Sprintf("authentication failed: too many failed attempts from %s", addr)
+		conn.CloseWithError(RejectCodeTooManyFailures.ApplicationErrorCode(), errString)
+		return nil, errors.New(errString)
+	}
+
+	if !h.acquire() {
+		errString := "authentication failed: too many pending authentications"
+		conn.CloseWithError(RejectCodeOverloaded.ApplicationErrorCode(), errString)
+		return nil, errors.New(errString)
+	}
+	defer h.release()
+
+	deadline := h.deadline()
+	ctx := s.ctx
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	stream0, err := conn.AcceptStream(ctx)
+	if err != nil {
+		errString := "authentication failed: control stream is not opened"
+		conn.CloseWithError(RejectCodeHandshakeTimeout.ApplicationErrorCode(), errString)
+		h.fail(addr)
+		s.logger.Debug("failed to accept control stream", "remote_addr", addr, "error", err)
+		return nil, err
+	}
+
+	ctrl := NewServerController(s.ctx, conn, stream0, s.frw, s.logger, randomID)
+	ctrl.server = s
+
+	if err := ctrl.stream0.SetDeadline(deadline); err != nil {
+		h.fail(addr)
+		return nil, ctrl.authFailed(RejectCodeAuthFailed, err)
+	}
+
+	// the client is rejected and disconnected by VerifyAuthentication if it fails.
+	_, err = ctrl.VerifyAuthentication(s.verifyAuthentication)
+	if err != nil {
+		h.fail(addr)
+		s.logger.Debug("client authentication failed", "remote_addr", addr, "error", err)
+		return nil, err
+	}
+
+	return ctrl, nil
+}
+
+// remoteHost returns the host of addr, the port is ignored because
+// clients can reconnect from different ports.
+func remoteHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		return udp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
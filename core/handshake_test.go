@@ -0,0 +1,128 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/auth"
+)
+
+func TestNewHandshake(t *testing.T) {
+	assert.Nil(t, newHandshake(nil))
+
+	option := &HandshakeOption{MaxPending: 2}
+	h := newHandshake(option)
+
+	assert.Equal(t, defaultFailureWindow, h.option.FailureWindow)
+	assert.Equal(t, 2, cap(h.pending))
+	// the option of the caller is not modified.
+	assert.Equal(t, &HandshakeOption{MaxPending: 2}, option)
+}
+
+func TestHandshakeFailures(t *testing.T) {
+	h := newHandshake(&HandshakeOption{MaxFailures: 2, FailureWindow: 50 * time.Millisecond})
+
+	h.fail("10.0.0.1")
+	assert.False(t, h.blocked("10.0.0.1"))
+	h.fail("10.0.0.1")
+	assert.True(t, h.blocked("10.0.0.1"))
+	assert.False(t, h.blocked("10.0.0.2"))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, h.blocked("10.0.0.1"))
+}
+
+// dialIdle dials the server at addr without opening the control stream.
+func dialIdle(t *testing.T, ctx context.Context, addr string) quic.Connection {
+	conn, err := quic.DialAddr(ctx, addr, insecureTLSConfig(), nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.CloseWithError(0, "") })
+
+	return conn
+}
+
+func TestHandshakeMaxPending(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := listenServer(t, ctx, &ServerOption{Handshake: &HandshakeOption{MaxPending: 1}})
+
+	// the connection that never opens the control stream holds the pending slot.
+	idle := dialIdle(t, ctx, addr)
+	time.Sleep(100 * time.Millisecond)
+
+	_, err := OpenClient(ctx, addr, WithTLSConfig(insecureTLSConfig()))
+	assert.ErrorIs(t, err, RejectCodeOverloaded)
+
+	// the slot is released once the idle connection is gone.
+	idle.CloseWithError(0, "")
+	time.Sleep(100 * time.Millisecond)
+	openTestClient(t, ctx, addr)
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := listenServer(t, ctx, &ServerOption{Handshake: &HandshakeOption{Timeout: 100 * time.Millisecond}})
+
+	idle := dialIdle(t, ctx, addr)
+
+	_, err := idle.AcceptStream(ctx)
+	assert.ErrorIs(t, asRejectedError(err), RejectCodeHandshakeTimeout)
+}
+
+func TestHandshakeBrokenControlStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := listenServer(t, ctx, &ServerOption{Handshake: &HandshakeOption{Timeout: 200 * time.Millisecond}})
+
+	tests := []struct {
+		name string
+		data []byte
+		code RejectCode
+	}{
+		// the control stream ends in the middle of the first frame.
+		{name: "malformed frame", data: []byte{0xff}, code: RejectCodeUnexpectedFrame},
+		// the control stream is opened but the first frame is never completed.
+		{name: "silent", data: []byte{}, code: RejectCodeHandshakeTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialIdle(t, ctx, addr)
+			stream, err := conn.OpenStreamSync(ctx)
+			assert.NoError(t, err)
+			_, err = stream.Write(append(tt.data, 0))
+			assert.NoError(t, err)
+			if tt.code == RejectCodeUnexpectedFrame {
+				assert.NoError(t, stream.Close())
+			}
+
+			// the connection is closed rather than left open until it is idle.
+			_, err = conn.AcceptStream(ctx)
+			assert.ErrorIs(t, asRejectedError(err), tt.code)
+		})
+	}
+}
+
+func TestHandshakeMaxFailures(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	registry := auth.NewRegistry()
+	registry.Register(auth.NewTokenAuth(auth.Token{Token: "secret"}))
+	addr := listenServer(t, ctx, &ServerOption{Auth: registry, Handshake: &HandshakeOption{MaxFailures: 1}})
+
+	_, err := OpenClient(ctx, addr, WithTLSConfig(insecureTLSConfig()), WithCredential("token:wrong"))
+	assert.ErrorIs(t, err, RejectCodeAuthFailed)
+
+	// the address is blocked even with the right credential.
+	_, err = OpenClient(ctx, addr, WithTLSConfig(insecureTLSConfig()), WithCredential("token:secret"))
+	assert.ErrorIs(t, err, RejectCodeTooManyFailures)
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/woorui/ydesign/core/auth"
+)
+
+// DuplicateNamePolicy decides what the server does when a client claims the name of a connected client.
+type DuplicateNamePolicy int
+
+const (
+	// DuplicateNameAllow allows clients to have the same name, the names are not tracked.
+	DuplicateNameAllow DuplicateNamePolicy = iota
+	// DuplicateNameReject rejects the new client, the connected client keeps the name.
+	DuplicateNameReject
+	// DuplicateNameTakeover disconnects the connected client and transfers its observed tags to the new client,
+	// It suits the clients that reconnect after network flaps and leave their stale sessions behind.
+	DuplicateNameTakeover
+)
+
+// names tracks the sessions of the named clients.
+type names struct {
+	policy DuplicateNamePolicy
+
+	mu sync.Mutex
+	// sessions is keyed by nameKey, so the clients of different tenants or principals never share a name.
+	sessions map[string]*ServerController
+}
+
+func newNames(policy DuplicateNamePolicy) *names {
+	return &names{
+		policy:   policy,
+		sessions: make(map[string]*ServerController),
+	}
+}
+
+// nameKey returns the key of the name of ctrl in names, It scopes the name chosen by client to the tenant of session
+// and the principal authenticated, so a client cannot reject or take over the sessions of others by claiming their names.
+//
+// The principal is the metadata returned by authentication except `auth.ExpiresAtKey`,
+// which changes whenever the client gets a fresh credential.
+func nameKey(ctrl *ServerController, session *tenantSession) string {
+	md := ctrl.Metadata().Clone()
+	md.Del(auth.ExpiresAtKey)
+	principal := md.Hash()
+
+	return session.namespace(hex.EncodeToString(principal[:]) + tenantSeparator + ctrl.Name())
+}
+
+// claim claims the name of ctrl for it with the key returned by nameKey. It returns the session taken over by ctrl,
+// or an error if the name is in use and the policy rejects the new client.
+func (n *names) claim(key string, ctrl *ServerController) (*ServerController, error) {
+	if ctrl.Name() == "" || n.policy == DuplicateNameAllow {
+		return nil, nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	old, ok := n.sessions[key]
+	if ok && n.policy == DuplicateNameReject {
+		return nil, fmt.Errorf("duplicate name: client %s is connected", ctrl.Name())
+	}
+	n.sessions[key] = ctrl
+
+	return old, nil
+}
+
+// release releases the name claimed by ctrl with the key, the name is kept if it is taken over by another session.
+func (n *names) release(key string, ctrl *ServerController) {
+	if ctrl.Name() == "" || n.policy == DuplicateNameAllow {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.sessions[key] == ctrl {
+		delete(n.sessions, key)
+	}
+}
+
+// takeover disconnects the old session and returns the tags it observed.
+func (s *Server) takeover(old, ctrl *ServerController) []string {
+	s.unobserve(old.ID())
+	old.rejectWithCloseConn(RejectCodeTakenOver, fmt.Sprintf("taken over: client %s connects again", old.Name()))
+
+	tags := old.observedTags()
+	s.logger.Debug("client session is taken over", "name", ctrl.Name(), "old_conn_id", old.ID(), "conn_id", ctrl.ID(), "tags", tags)
+
+	return tags
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/auth"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+func TestNameKey(t *testing.T) {
+	key := func(tenantID string, md metadata.MD, name string) string {
+		var session *tenantSession
+		if tenantID != "" {
+			session = &tenantSession{tenant: &tenant{id: tenantID}}
+		}
+		return nameKey(&ServerController{name: name, md: md}, session)
+	}
+	alice := metadata.MD{"user": {"alice"}}
+	refreshed := metadata.MD{"user": {"alice"}, auth.ExpiresAtKey: {strconv.FormatInt(time.Now().Unix(), 10)}}
+
+	assert.Equal(t, key("", alice, "dev"), key("", refreshed, "dev"))
+	assert.NotEqual(t, key("", alice, "dev"), key("", alice, "dev-2"))
+	assert.NotEqual(t, key("", alice, "dev"), key("", metadata.MD{"user": {"bob"}}, "dev"))
+	assert.NotEqual(t, key("a", alice, "dev"), key("b", alice, "dev"))
+}
+
+// namingRegistry authenticates the tokens of alice, bob and the tenants of them.
+func namingRegistry() *auth.Registry {
+	registry := auth.NewRegistry()
+	registry.Register(auth.NewTokenAuth(
+		auth.Token{Token: "alice-1", Metadata: metadata.MD{"user": {"alice"}, "tenant": {"a"}}},
+		auth.Token{Token: "alice-2", Metadata: metadata.MD{"user": {"alice"}, "tenant": {"a"}}},
+		auth.Token{Token: "bob", Metadata: metadata.MD{"user": {"bob"}, "tenant": {"a"}}},
+		auth.Token{Token: "alice-b", Metadata: metadata.MD{"user": {"alice"}, "tenant": {"b"}}},
+	))
+	return registry
+}
+
+// rejection returns the rejection of the client opened with opts, the client is rejected after it is authenticated,
+// so the rejection may be returned by OpenClient or by observing. It returns nil if the client is not rejected in time.
+func rejection(t *testing.T, ctx context.Context, addr string, opts ...ClientOptionFunc) error {
+	opts = append([]ClientOptionFunc{WithTLSConfig(insecureTLSConfig())}, opts...)
+	client, err := OpenClient(ctx, addr, opts...)
+	if err != nil {
+		return err
+	}
+	t.Cleanup(func() { client.Close() })
+
+	_, errCh := observe(client, "rejection")
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(300 * time.Millisecond):
+		return nil
+	}
+}
+
+func TestDuplicateNameReject(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := listenServer(t, ctx, &ServerOption{Auth: namingRegistry(), DuplicateName: DuplicateNameReject})
+
+	first := openTestClient(t, ctx, addr, WithCredential("token:alice-1"), WithName("dev"))
+	_, firstErr := observe(first, "sensors")
+
+	// the same principal cannot claim the name twice.
+	err := rejection(t, ctx, addr, WithCredential("token:alice-2"), WithName("dev"))
+	assert.ErrorIs(t, err, RejectCodeDuplicateName)
+
+	// the name is scoped to the principal, another principal with the same name is not rejected.
+	assert.NoError(t, rejection(t, ctx, addr, WithCredential("token:bob"), WithName("dev")))
+
+	assert.Empty(t, firstErr)
+}
+
+func TestDuplicateNameTakeover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := listenServer(t, ctx, &ServerOption{Auth: namingRegistry(), DuplicateName: DuplicateNameTakeover})
+
+	old := openTestClient(t, ctx, addr, WithCredential("token:alice-1"), WithName("dev"))
+	_, oldErr := observe(old, "sensors")
+	time.Sleep(100 * time.Millisecond)
+
+	// another principal cannot take over the session by claiming its name.
+	assert.NoError(t, rejection(t, ctx, addr, WithCredential("token:bob"), WithName("dev")))
+	assert.Empty(t, oldErr)
+
+	// the same principal takes over the session and inherits the observed tags.
+	client := openTestClient(t, ctx, addr, WithCredential("token:alice-2"), WithName("dev"))
+	select {
+	case err := <-oldErr:
+		assert.ErrorIs(t, err, RejectCodeTakenOver)
+	case <-ctx.Done():
+		t.Fatal("the old session is not taken over")
+	}
+
+	// the streams are dispatched by their tags, so the client observes the inherited tag to handle them.
+	streams, _ := observe(client, "sensors")
+	time.Sleep(100 * time.Millisecond)
+	w, err := openTestClient(t, ctx, addr, WithCredential("token:bob")).Open("sensors", nil)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("21.5"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	o := receive(t, streams)
+	assert.Equal(t, "sensors", o.header.Tag)
+	assert.Equal(t, "21.5", string(o.data))
+}
+
+func TestDuplicateNameAcrossTenants(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := listenServer(t, ctx, &ServerOption{
+		Auth:          namingRegistry(),
+		Tenant:        &TenantOption{MetadataKey: "tenant", MaxConnections: 1},
+		DuplicateName: DuplicateNameTakeover,
+	})
+
+	client := openTestClient(t, ctx, addr, WithCredential("token:alice-1"), WithName("dev"))
+	_, clientErr := observe(client, "sensors")
+	time.Sleep(100 * time.Millisecond)
+
+	// the client of another tenant has its own name.
+	assert.NoError(t, rejection(t, ctx, addr, WithCredential("token:alice-b"), WithName("dev")))
+
+	// the client rejected by its tenant never takes over the session.
+	err := rejection(t, ctx, addr, WithCredential("token:alice-2"), WithName("dev"))
+	assert.ErrorIs(t, err, RejectCodeLimitExceeded)
+
+	assert.Empty(t, clientErr)
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"io"
+
+	"github.com/quic-go/quic-go"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// InterceptedStream is a tagged stream that is intercepted by StreamInterceptor before it is docked.
+type InterceptedStream struct {
+	// Header is the header of the stream, Interceptors can rewrite the tag of the header.
+	Header *StreamHeader
+	// Metadata is the metadata of the writer, It is returned by the authentication of the writer connection.
+	Metadata metadata.MD
+	// Reader is the stream data, Interceptors can replace it with a wrapped one.
+	Reader ReadStream
+}
+
+// StreamInterceptor intercepts every tagged stream before it is docked to observers.
+type StreamInterceptor interface {
+	// Intercept intercepts the stream. Returning an error rejects the stream,
+	// the writer will be aborted with the error code if the error is a *quic.StreamError.
+	Intercept(*InterceptedStream) error
+}
+
+// StreamInterceptorFunc intercepts tagged streams.
+type StreamInterceptorFunc func(stream *InterceptedStream) error
+
+// Intercept calls StreamInterceptorFunc itself.
+func (f StreamInterceptorFunc) Intercept(stream *InterceptedStream) error { return f(stream) }
+
+// intercept calls the interceptors in order, it stops at the first interceptor that rejects the stream.
+func intercept(interceptors []StreamInterceptor, stream *InterceptedStream) error {
+	for _, interceptor := range interceptors {
+		if err := interceptor.Intercept(stream); err != nil {
+			return err
+		}
+	}
+	return stream.Header.validate()
+}
+
+// VerifyMetadataSignature returns a StreamInterceptor that rejects the streams whose metadata is not signed with the key
+// for the tag and the ID of the stream, The metadata is signed by `metadata.MD.SignStream`, or by the client with
+// `ClientOption.MetadataSigningKey`. It should be placed before the interceptors that rewrite the header.
+func VerifyMetadataSignature(key []byte) StreamInterceptor {
+	return StreamInterceptorFunc(func(stream *InterceptedStream) error {
+		if stream.Header.Metadata.VerifyStream(key, stream.Header.Tag, stream.Header.ID) {
+			return nil
+		}
+		return &quic.StreamError{ErrorCode: RejectCodeForbidden.StreamErrorCode()}
+	})
+}
+
+// WrapReadStream returns a ReadStream that reads from r and cancels rs when it is canceled.
+// It is used to decompress, meter or inspect the stream data in interceptors.
+func WrapReadStream(rs ReadStream, r io.Reader) ReadStream {
+	return &wrappedReadStream{Reader: r, rs: rs}
+}
+
+// TeeReadStream returns a ReadStream that writes to w what it reads from rs.
+// It is used to fork a copy of the stream data to an audit sink in interceptors.
+func TeeReadStream(rs ReadStream, w io.Writer) ReadStream {
+	return WrapReadStream(rs, io.TeeReader(rs, w))
+}
+
+type wrappedReadStream struct {
+	io.Reader
+	rs ReadStream
+}
+
+func (s *wrappedReadStream) CancelRead(code quic.StreamErrorCode) { s.rs.CancelRead(code) }
@@ -0,0 +1,245 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/acl"
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+func TestIntercept(t *testing.T) {
+	var called []string
+	record := func(name string, err error) StreamInterceptor {
+		return StreamInterceptorFunc(func(stream *InterceptedStream) error {
+			called = append(called, name)
+			return err
+		})
+	}
+	stream := func() *InterceptedStream {
+		return &InterceptedStream{Header: &StreamHeader{ID: "1", Tag: "sensors"}}
+	}
+
+	// the interceptors are called in order, and the first rejection stops the others.
+	rejected := errors.New("rejected")
+	err := intercept([]StreamInterceptor{record("a", nil), record("b", rejected), record("c", nil)}, stream())
+	assert.ErrorIs(t, err, rejected)
+	assert.Equal(t, []string{"a", "b"}, called)
+
+	// the header rewritten must be valid.
+	clearTag := StreamInterceptorFunc(func(stream *InterceptedStream) error {
+		stream.Header.Tag = ""
+		return nil
+	})
+	assert.Error(t, intercept([]StreamInterceptor{clearTag}, stream()))
+	assert.NoError(t, intercept(nil, stream()))
+}
+
+func TestVerifyMetadataSignature(t *testing.T) {
+	key := []byte("secret")
+	signed := func(tag, id string) metadata.MD {
+		md := metadata.MD{"trace_id": {"t1"}}
+		assert.NoError(t, md.SignStream(key, tag, id))
+		return md
+	}
+	legacy := metadata.MD{"trace_id": {"t1"}}
+	assert.NoError(t, legacy.Sign(key))
+
+	tests := []struct {
+		name    string
+		md      metadata.MD
+		allowed bool
+	}{
+		{name: "signed", md: signed("sensors", "1"), allowed: true},
+		{name: "unsigned", md: metadata.MD{"trace_id": {"t1"}}},
+		{name: "another stream", md: signed("sensors", "2")},
+		{name: "another tag", md: signed("logs", "1")},
+		{name: "not bound to stream", md: legacy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyMetadataSignature(key).Intercept(&InterceptedStream{
+				Header: &StreamHeader{ID: "1", Tag: "sensors", Metadata: tt.md},
+			})
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, RejectCodeForbidden.StreamErrorCode(), streamErrorCode(err))
+			}
+		})
+	}
+}
+
+func TestMetadataSigningKey(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key := []byte("secret")
+	addr := listenServer(t, ctx, &ServerOption{Interceptors: []StreamInterceptor{VerifyMetadataSignature(key)}})
+	streams, _ := observe(openTestClient(t, ctx, addr), "sensors")
+
+	// the client signs the metadata of the streams it opens.
+	signer := openTestClient(t, ctx, addr, WithMetadataSigningKey(key))
+	w, err := signer.Open("sensors", metadata.MD{"trace_id": {"t1"}})
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("21.5"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	o := receive(t, streams)
+	assert.Equal(t, "21.5", string(o.data))
+	assert.True(t, o.header.Metadata.VerifyStream(key, "sensors", o.header.ID))
+
+	// the signed metadata cannot be replayed on another stream.
+	data, err := o.header.Metadata.Encode()
+	assert.NoError(t, err)
+	err = writeRawHeader(t, openTestClient(t, ctx, addr), &frame.OpenStreamFrame{ID: o.header.ID + "-replayed", Tag: "sensors", Metadata: data})
+	assert.ErrorIs(t, err, RejectCodeForbidden)
+
+	// the client without the key is rejected.
+	err = writeRawHeader(t, openTestClient(t, ctx, addr), &frame.OpenStreamFrame{ID: "1", Tag: "sensors"})
+	assert.ErrorIs(t, err, RejectCodeForbidden)
+}
+
+func TestStreamInterceptor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		mu    sync.Mutex
+		audit bytes.Buffer
+	)
+	interceptors := []StreamInterceptor{
+		// reject the tag.
+		StreamInterceptorFunc(func(stream *InterceptedStream) error {
+			if stream.Header.Tag == "secret" {
+				return &quic.StreamError{ErrorCode: RejectCodeForbidden.StreamErrorCode()}
+			}
+			if stream.Header.Tag == "broken" {
+				return errors.New("interceptor is broken")
+			}
+			return nil
+		}),
+		// rewrite the tag and the data, and fork the data to the audit.
+		StreamInterceptorFunc(func(stream *InterceptedStream) error {
+			if stream.Header.Tag != "raw" {
+				return nil
+			}
+			stream.Header.Tag = "sensors"
+			if err := stream.Header.Metadata.Set("rewritten", "true"); err != nil {
+				return err
+			}
+			stream.Reader = WrapReadStream(stream.Reader, &upperReader{r: stream.Reader})
+			stream.Reader = TeeReadStream(stream.Reader, &lockedWriter{mu: &mu, w: &audit})
+			return nil
+		}),
+	}
+	addr := listenServer(t, ctx, &ServerOption{Interceptors: interceptors})
+	writer := openTestClient(t, ctx, addr)
+
+	// the stream rewritten is docked to the observers of the new tag.
+	streams, _ := observe(openTestClient(t, ctx, addr), "sensors")
+	w, err := writer.Open("raw", metadata.MD{"unit": {"celsius"}})
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("temperature"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	o := receive(t, streams)
+	assert.NoError(t, o.err)
+	assert.Equal(t, "sensors", o.header.Tag)
+	assert.Equal(t, metadata.MD{"unit": {"celsius"}, "rewritten": {"true"}}, o.header.Metadata)
+	assert.Equal(t, "TEMPERATURE", string(o.data))
+	mu.Lock()
+	assert.Equal(t, "TEMPERATURE", audit.String())
+	mu.Unlock()
+
+	// the stream rejected is canceled with the code of the interceptor,
+	// and canceled as lost if the error has no code.
+	for tag, code := range map[string]quic.StreamErrorCode{
+		"secret": RejectCodeForbidden.StreamErrorCode(),
+		"broken": streamCanceledCode,
+	} {
+		w, err := writer.Open(tag, nil)
+		assert.NoError(t, err)
+
+		var serr *quic.StreamError
+		assert.Eventually(t, func() bool {
+			_, err = w.Write([]byte("data"))
+			return errors.As(err, &serr)
+		}, 5*time.Second, 10*time.Millisecond, tag)
+		if serr != nil {
+			assert.Equal(t, code, serr.ErrorCode, tag)
+		}
+	}
+}
+
+func TestStreamInterceptorACL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rules, err := acl.Parse("* may publish raw", "* may publish public", "* may publish sensors", "* may observe *")
+	assert.NoError(t, err)
+	rewrite := StreamInterceptorFunc(func(stream *InterceptedStream) error {
+		switch stream.Header.Tag {
+		case "raw":
+			stream.Header.Tag = "secret"
+		case "public":
+			stream.Header.Tag = "sensors"
+		}
+		return nil
+	})
+	addr := listenServer(t, ctx, &ServerOption{ACL: rules, Interceptors: []StreamInterceptor{rewrite}})
+	writer := openTestClient(t, ctx, addr)
+
+	// the tag rewritten is not allowed to publish.
+	w, err := writer.Open("raw", nil)
+	assert.NoError(t, err)
+	var serr *quic.StreamError
+	assert.Eventually(t, func() bool {
+		_, err = w.Write([]byte("data"))
+		return errors.As(err, &serr)
+	}, 5*time.Second, 10*time.Millisecond)
+	if serr != nil {
+		assert.Equal(t, RejectCodeForbidden.StreamErrorCode(), serr.ErrorCode)
+	}
+
+	// both the tag and the tag rewritten are allowed to publish.
+	streams, _ := observe(openTestClient(t, ctx, addr), "sensors")
+	w, err = writer.Open("public", nil)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("21.5"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.Equal(t, "21.5", string(receive(t, streams).data))
+}
+
+// upperReader reads from r in upper case.
+type upperReader struct{ r io.Reader }
+
+func (u *upperReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	copy(p, strings.ToUpper(string(p[:n])))
+	return n, err
+}
+
+// lockedWriter writes to w with mu held.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
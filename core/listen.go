@@ -0,0 +1,58 @@
+package core
+
+import (
+	"crypto/tls"
+
+	"github.com/quic-go/quic-go"
+)
+
+// ListenAndServe listens on the UDP address addr and serves the clients, `ALPN` is added to
+// the NextProtos of tlsConfig if it is empty. It returns when the server is closed.
+func (s *Server) ListenAndServe(addr string, tlsConfig *tls.Config, quicConfig *quic.Config) error {
+	if len(tlsConfig.NextProtos) == 0 {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.NextProtos = []string{ALPN}
+	}
+
+	ln, err := quic.ListenAddr(addr, tlsConfig, quicConfig)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ln)
+}
+
+// Serve accepts the connections from ln and serves them, ln is closed when the server is closed.
+// It returns nil when the server is closed.
+func (s *Server) Serve(ln *quic.Listener) error {
+	go func() {
+		<-s.ctx.Done()
+		// reject the clients before closing ln, which drops the connections silently.
+		s.goAway()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept(s.ctx)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+// handleConnection authenticates the client of conn with the control stream opened by it, and then serves it.
+func (s *Server) handleConnection(conn quic.Connection) {
+	ctrl, err := s.authenticate(conn)
+	if err != nil {
+		return
+	}
+	s.controllers.Store(ctrl.ID(), ctrl)
+	defer s.controllers.Delete(ctrl.ID())
+	s.logger.Debug("client is authenticated", "conn_id", ctrl.ID(), "name", ctrl.Name())
+
+	s.serve(ctrl)
+}
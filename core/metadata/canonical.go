@@ -0,0 +1,109 @@
+package metadata
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// SignatureKey is the key of the signature set by Sign, it is excluded from the signed metadata.
+const SignatureKey = "md_signature"
+
+// Equal reports whether md and other are canonically equal, that is, they have the same encoding.
+// The canonical encoding only sorts the keys, the keys and the values are compared verbatim:
+// there is no case folding, Unicode normalization or trimming, so "Celsius" and "celsius" differ.
+// The keys without values are ignored, so a nil MD equals an empty MD. The order of values matters.
+func (md MD) Equal(other MD) bool {
+	if md.numKeys() != other.numKeys() {
+		return false
+	}
+	for k, values := range md {
+		if len(values) == 0 {
+			continue
+		}
+		others := other[k]
+		if len(values) != len(others) {
+			return false
+		}
+		for i := range values {
+			if values[i] != others[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// numKeys returns the number of keys that have values.
+func (md MD) numKeys() int {
+	n := 0
+	for _, values := range md {
+		if len(values) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Hash returns the SHA-256 hash of the canonical encoding of md, the canonically equal MDs have the same hash.
+// The values are hashed byte for byte like they are compared by Equal.
+func (md MD) Hash() [sha256.Size]byte {
+	data, _ := md.Encode()
+	return sha256.Sum256(data)
+}
+
+// Sign sets SignatureKey to the HMAC-SHA256 of the canonical encoding of md with the key,
+// The signature is carried with md, so it can be verified wherever md is received.
+// The values are signed byte for byte, a value rewritten to an equivalent form invalidates the signature.
+func (md MD) Sign(key []byte) error {
+	return md.Set(SignatureKey, hex.EncodeToString(md.signature(key)))
+}
+
+// Verify reports whether SignatureKey of md is the signature of the rest of md with the key.
+func (md MD) Verify(key []byte) bool {
+	return md.verify(md.signature(key))
+}
+
+// SignStream signs md like Sign, but the signature also covers the tag and the ID of the stream that carries md,
+// so the signed metadata cannot be replayed on other streams.
+func (md MD) SignStream(key []byte, tag, id string) error {
+	return md.Set(SignatureKey, hex.EncodeToString(md.signature(key, streamSignatureDomain, tag, id)))
+}
+
+// VerifyStream reports whether SignatureKey of md is the signature set by SignStream with the key, the tag and the ID.
+func (md MD) VerifyStream(key []byte, tag, id string) bool {
+	return md.verify(md.signature(key, streamSignatureDomain, tag, id))
+}
+
+// streamSignatureDomain separates the signatures of SignStream from the ones of Sign.
+const streamSignatureDomain = "stream"
+
+func (md MD) verify(expected []byte) bool {
+	signature, ok := md.Get(SignatureKey)
+	if !ok {
+		return false
+	}
+	decoded, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(decoded, expected)
+}
+
+// signature returns the HMAC-SHA256 of the bound strings and the canonical encoding of md, SignatureKey excluded.
+// The bound strings are length-prefixed, so they never run into each other or the encoding.
+func (md MD) signature(key []byte, bound ...string) []byte {
+	signed := md
+	if _, ok := md[SignatureKey]; ok {
+		signed = md.Clone()
+		signed.Del(SignatureKey)
+	}
+	data, _ := signed.Encode()
+
+	mac := hmac.New(sha256.New, key)
+	for _, s := range bound {
+		mac.Write(appendString(nil, s))
+	}
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,17 @@
+package metadata
+
+import "context"
+
+type contextKey struct{}
+
+// NewContext returns a new context that carries md.
+func NewContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, contextKey{}, md)
+}
+
+// FromContext returns the md carried by ctx, if any.
+// The returned md should not be modified, clone it before modifying.
+func FromContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(contextKey{}).(MD)
+	return md, ok
+}
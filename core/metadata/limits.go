@@ -0,0 +1,109 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTooLarge is returned if the encoded metadata exceeds Limits.MaxSize.
+	ErrTooLarge = errors.New("metadata: encoded size exceeds the limit")
+	// ErrTooManyKeys is returned if the metadata exceeds Limits.MaxKeys or Limits.MaxValues.
+	ErrTooManyKeys = errors.New("metadata: number of keys or values exceeds the limit")
+	// ErrTooLong is returned if a key or value exceeds Limits.MaxKeyLength or Limits.MaxValueLength.
+	ErrTooLong = errors.New("metadata: length of key or value exceeds the limit")
+)
+
+// Limits limits the size and cardinality of metadata, A zero field means no limit.
+type Limits struct {
+	// MaxSize limits the size of the encoded metadata in bytes.
+	MaxSize int
+	// MaxKeys limits the number of distinct keys.
+	MaxKeys int
+	// MaxValues limits the number of values of all keys.
+	MaxValues int
+	// MaxKeyLength limits the length of a key in bytes.
+	MaxKeyLength int
+	// MaxValueLength limits the length of a value in bytes.
+	MaxValueLength int
+}
+
+// DefaultLimits returns the limits that are generous for the metadata of streams and authentication,
+// but keep a peer from exhausting the memory with huge metadata. The server uses them by default.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxSize:        64 << 10,
+		MaxKeys:        128,
+		MaxValues:      1024,
+		MaxKeyLength:   256,
+		MaxValueLength: 16 << 10,
+	}
+}
+
+// Check checks whether md is within the limits.
+func (l Limits) Check(md MD) error {
+	if l.MaxKeys > 0 && len(md) > l.MaxKeys {
+		return fmt.Errorf("%w: %d keys, the limit is %d", ErrTooManyKeys, len(md), l.MaxKeys)
+	}
+
+	values := 0
+	for k, vv := range md {
+		if err := l.checkKey(len(k)); err != nil {
+			return err
+		}
+		for _, v := range vv {
+			if err := l.checkValue(len(v)); err != nil {
+				return err
+			}
+		}
+		values += len(vv)
+	}
+	if err := l.checkValues(uint64(values)); err != nil {
+		return err
+	}
+
+	if l.MaxSize > 0 {
+		data, err := md.Encode()
+		if err != nil {
+			return err
+		}
+		return l.checkSize(len(data))
+	}
+
+	return nil
+}
+
+func (l Limits) checkSize(n int) error {
+	if l.MaxSize > 0 && n > l.MaxSize {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrTooLarge, n, l.MaxSize)
+	}
+	return nil
+}
+
+func (l Limits) checkKeys(n int) error {
+	if l.MaxKeys > 0 && n > l.MaxKeys {
+		return fmt.Errorf("%w: more than %d keys", ErrTooManyKeys, l.MaxKeys)
+	}
+	return nil
+}
+
+func (l Limits) checkValues(n uint64) error {
+	if l.MaxValues > 0 && n > uint64(l.MaxValues) {
+		return fmt.Errorf("%w: %d values, the limit is %d", ErrTooManyKeys, n, l.MaxValues)
+	}
+	return nil
+}
+
+func (l Limits) checkKey(n int) error {
+	if l.MaxKeyLength > 0 && n > l.MaxKeyLength {
+		return fmt.Errorf("%w: key of %d bytes, the limit is %d", ErrTooLong, n, l.MaxKeyLength)
+	}
+	return nil
+}
+
+func (l Limits) checkValue(n int) error {
+	if l.MaxValueLength > 0 && n > l.MaxValueLength {
+		return fmt.Errorf("%w: value of %d bytes, the limit is %d", ErrTooLong, n, l.MaxValueLength)
+	}
+	return nil
+}
@@ -3,27 +3,55 @@ package metadata
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
-	"strings"
+	"fmt"
+	"math"
+	"sort"
 )
 
 var (
-	ErrMDKeyOrValue  = errors.New("metadata: key or value cannot contain '\n' and  ':'")
-	ErrInvalidFormat = errors.New("metadata: invalid format")
+	// Deprecated: keys and values can contain any bytes since the binary encoding.
+	ErrMDKeyOrValue          = errors.New("metadata: key or value cannot contain '\n' and  ':'")
+	ErrInvalidFormat         = errors.New("metadata: invalid format")
+	ErrUnsupportedVersion    = errors.New("metadata: unsupported encoding version")
+	errTruncatedBinaryFormat = errors.New("metadata: truncated binary format")
 )
 
-type MD map[string]string
+// binaryMarker is the first byte of the binary encoding, It never starts the text encoding produced by the
+// earlier versions, so both encodings can be decoded by `Decode`.
+const binaryMarker = 0x00
 
+// binaryVersion is the version of the binary encoding, it follows binaryMarker.
+const binaryVersion = 0x01
+
+// MD is the metadata, It maps a key to multiple values like http.Header.
+type MD map[string][]string
+
+// Get returns the first value of the key.
 func (md MD) Get(key string) (string, bool) {
-	value, ok := md[key]
-	return value, ok
+	values := md[key]
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// Values returns all the values of the key, the returned slice should not be modified.
+func (md MD) Values(key string) []string {
+	return md[key]
 }
 
+// Set sets the value of the key, It replaces the existing values. The key and value can contain any bytes.
 func (md MD) Set(key, value string) error {
-	if strings.ContainsAny(key, ":\n") || strings.ContainsAny(value, ":\n") {
-		return ErrMDKeyOrValue
-	}
-	md[key] = value
+	md[key] = []string{value}
+	return nil
+}
+
+// Add appends the value to the values of the key.
+func (md MD) Add(key, value string) error {
+	md[key] = append(md[key], value)
 	return nil
 }
 
@@ -41,55 +69,228 @@ func (md MD) Clone() MD {
 
 	md2 := make(MD, len(md))
 	for k, v := range md {
-		md2[k] = v
+		md2[k] = append([]string(nil), v...)
 	}
 
 	return md2
 }
 
+// UnmarshalJSON decodes the JSON object to md, the value of a key can be a string or an array of strings.
+func (md *MD) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*md = nil
+		return nil
+	}
+
+	decoded := make(MD, len(raw))
+	for k, v := range raw {
+		var value string
+		if err := json.Unmarshal(v, &value); err == nil {
+			decoded[k] = []string{value}
+			continue
+		}
+		var values []string
+		if err := json.Unmarshal(v, &values); err != nil {
+			return fmt.Errorf("metadata: value of %s must be a string or an array of strings", k)
+		}
+		decoded[k] = values
+	}
+	*md = decoded
+
+	return nil
+}
+
+// Encode encodes md in the binary encoding, The encoding is canonical: the keys are sorted and the keys without values
+// are omitted, so the canonically equal MDs are always encoded to the same bytes. See `Equal`.
+//
+// The binary encoding is a marker byte, a version byte, the number of pairs and then the pairs,
+// Every key and value is prefixed with its length, all the numbers are uvarint.
+// A key with multiple values is encoded as multiple pairs in the order of the values.
 func (md MD) Encode() ([]byte, error) {
 	var (
-		n   = 0
-		buf = new(bytes.Buffer)
+		keys  = make([]string, 0, len(md))
+		pairs = 0
+		size  = 2 + binary.MaxVarintLen64
 	)
-
-	for k, v := range md {
-		if n != len(md)-1 {
-			buf.Grow(len(k) + len(v) + 1)
-		} else {
-			buf.Grow(len(k) + len(v) + 2)
+	for k, values := range md {
+		keys = append(keys, k)
+		pairs += len(values)
+		for _, v := range values {
+			size += len(k) + len(v) + 2*binary.MaxVarintLen64
 		}
-		buf.WriteString(k)
-		buf.WriteString(":")
-		buf.WriteString(v)
+	}
+	sort.Strings(keys)
 
-		if n != len(md)-1 {
-			buf.WriteByte('\n')
+	buf := make([]byte, 0, size)
+	buf = append(buf, binaryMarker, binaryVersion)
+	buf = binary.AppendUvarint(buf, uint64(pairs))
+	for _, k := range keys {
+		for _, v := range md[k] {
+			buf = appendString(buf, k)
+			buf = appendString(buf, v)
 		}
-		n++
 	}
 
-	return buf.Bytes(), nil
+	return buf, nil
 }
 
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// Decode decodes data to md, data can be in the binary encoding or the text encoding of the earlier versions.
+// The keys in data replace the same keys in md, the values of a key repeated in data are its multiple values.
+// md is not modified if it fails.
 func (md MD) Decode(data []byte) error {
+	return md.DecodeWithLimits(data, Limits{})
+}
+
+// DecodeWithLimits decodes data to md like Decode, It fails as soon as data exceeds the limits,
+// before the keys and values are allocated.
+func (md MD) DecodeWithLimits(data []byte, limits Limits) error {
+	if err := limits.checkSize(len(data)); err != nil {
+		return err
+	}
+	if len(data) > 0 && data[0] == binaryMarker {
+		return md.decodeBinary(data[1:], limits)
+	}
+	return md.decodeText(data, limits)
+}
+
+func (md MD) decodeBinary(data []byte, limits Limits) error {
+	if len(data) == 0 {
+		return errTruncatedBinaryFormat
+	}
+	if data[0] != binaryVersion {
+		return ErrUnsupportedVersion
+	}
+	data = data[1:]
+
+	n, data, err := readUvarint(data)
+	if err != nil {
+		return err
+	}
+	// every pair takes two bytes at least, it avoids allocating for a forged count.
+	if n > uint64(len(data)/2) {
+		return errTruncatedBinaryFormat
+	}
+	if err := limits.checkValues(n); err != nil {
+		return err
+	}
+
+	var (
+		decoded = make(MD)
+		newKeys = 0
+	)
+	for i := uint64(0); i < n; i++ {
+		var k, v string
+		if k, data, err = readString(data, limits.checkKey); err != nil {
+			return err
+		}
+		if v, data, err = readString(data, limits.checkValue); err != nil {
+			return err
+		}
+		if _, ok := decoded[k]; !ok {
+			if _, ok := md[k]; !ok {
+				newKeys++
+			}
+			if err := limits.checkKeys(len(md) + newKeys); err != nil {
+				return err
+			}
+		}
+		decoded[k] = append(decoded[k], v)
+	}
+	if len(data) != 0 {
+		return ErrInvalidFormat
+	}
+
+	md.replace(decoded)
+
+	return nil
+}
+
+// replace replaces the keys of md with the keys of decoded.
+func (md MD) replace(decoded MD) {
+	for k, v := range decoded {
+		md[k] = v
+	}
+}
+
+func readUvarint(data []byte) (uint64, []byte, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 {
+		return 0, nil, errTruncatedBinaryFormat
+	}
+	return n, data[size:], nil
+}
+
+// readString reads a length-prefixed string, check checks the length before the string is allocated.
+func readString(data []byte, check func(int) error) (string, []byte, error) {
+	n, data, err := readUvarint(data)
+	if err != nil {
+		return "", nil, err
+	}
+	// check the length before the truncation, so that a forged length reports the limit.
+	length := math.MaxInt32
+	if n < uint64(length) {
+		length = int(n)
+	}
+	if err := check(length); err != nil {
+		return "", nil, err
+	}
+	if n > uint64(len(data)) {
+		return "", nil, errTruncatedBinaryFormat
+	}
+	return string(data[:n]), data[n:], nil
+}
+
+// decodeText decodes the text encoding of the earlier versions, one `key:value` pair per line.
+// The values of the repeated keys are appended.
+func (md MD) decodeText(data []byte, limits Limits) error {
 	scanner := bufio.NewScanner(bytes.NewReader(data))
 	scanner.Split(bufio.ScanLines)
 
-	for scanner.Scan() {
+	var (
+		decoded = make(MD)
+		newKeys = 0
+	)
+	for values := uint64(1); scanner.Scan(); values++ {
 		line := scanner.Bytes()
 
 		parts := bytes.SplitN(line, []byte(":"), 2)
 		if len(parts) != 2 {
 			return ErrInvalidFormat
 		}
-
-		md[string(parts[0])] = string(parts[1])
+		if err := limits.checkValues(values); err != nil {
+			return err
+		}
+		if err := limits.checkKey(len(parts[0])); err != nil {
+			return err
+		}
+		if err := limits.checkValue(len(parts[1])); err != nil {
+			return err
+		}
+		k := string(parts[0])
+		if _, ok := decoded[k]; !ok {
+			if _, ok := md[k]; !ok {
+				newKeys++
+			}
+			if err := limits.checkKeys(len(md) + newKeys); err != nil {
+				return err
+			}
+		}
+		decoded[k] = append(decoded[k], string(parts[1]))
 	}
 
 	if scanner.Err() != nil {
 		return scanner.Err()
 	}
+	md.replace(decoded)
 
 	return nil
 }
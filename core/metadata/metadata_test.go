@@ -1,7 +1,10 @@
 package metadata
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -12,9 +15,6 @@ func TestMD(t *testing.T) {
 	err := md.Set("abc", "def")
 	assert.NoError(t, err)
 
-	err = md.Set("a:v", "vvvv")
-	assert.Error(t, ErrMDKeyOrValue, err)
-
 	md2 := md.Clone()
 	assert.Equal(t, md, md2)
 
@@ -24,7 +24,7 @@ func TestMD(t *testing.T) {
 
 	data, err := md.Encode()
 	assert.NoError(t, err)
-	assert.Equal(t, []byte{0x61, 0x62, 0x63, 0x3a, 0x64, 0x65, 0x66}, data)
+	assert.Equal(t, []byte{0x00, 0x01, 0x01, 0x03, 0x61, 0x62, 0x63, 0x03, 0x64, 0x65, 0x66}, data)
 
 	var md3 = MD{}
 
@@ -79,7 +79,240 @@ func TestDecode(t *testing.T) {
 	if len(md) != 1 {
 		t.Errorf("Expected 1 key-value pair, but got %d", len(md))
 	}
-	if md[""] != "" {
-		t.Errorf("Expected empty key-value pair, but got %s:%s", "", md[""])
+	if v, _ := md.Get(""); v != "" {
+		t.Errorf("Expected empty key-value pair, but got %s:%s", "", v)
+	}
+}
+
+func TestBinaryEncoding(t *testing.T) {
+	md := MD{}
+	assert.NoError(t, md.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	assert.NoError(t, md.Set("url", "https://[::1]:8080/a?b=c"))
+	assert.NoError(t, md.Set("json", "{\"a\":\n1}"))
+	assert.NoError(t, md.Set("", ""))
+
+	data, err := md.Encode()
+	assert.NoError(t, err)
+
+	// the keys are sorted, so the encoding is deterministic.
+	for i := 0; i < 10; i++ {
+		again, err := md.Clone().Encode()
+		assert.NoError(t, err)
+		assert.Equal(t, data, again)
+	}
+
+	decoded := MD{}
+	assert.NoError(t, decoded.Decode(data))
+	assert.Equal(t, md, decoded)
+
+	empty, err := MD{}.Encode()
+	assert.NoError(t, err)
+	decoded = MD{}
+	assert.NoError(t, decoded.Decode(empty))
+	assert.Equal(t, MD{}, decoded)
+
+	// the text encoding of the earlier versions is still readable.
+	decoded = MD{}
+	assert.NoError(t, decoded.Decode([]byte("content-type:text/plain; charset=utf-8\ntime:12:00")))
+	assert.Equal(t, MD{"content-type": {"text/plain; charset=utf-8"}, "time": {"12:00"}}, decoded)
+
+	for name, data := range map[string][]byte{
+		"no version":      {0x00},
+		"truncated count": {0x00, 0x01, 0x80},
+		"forged count":    {0x00, 0x01, 0x7f, 0x00, 0x00},
+		"truncated value": {0x00, 0x01, 0x01, 0x01, 'a', 0x05, 'b'},
+		"trailing bytes":  {0x00, 0x01, 0x00, 0x00},
+	} {
+		assert.Error(t, MD{}.Decode(data), name)
+	}
+	assert.ErrorIs(t, MD{}.Decode([]byte{0x00, 0x02, 0x00}), ErrUnsupportedVersion)
+}
+
+func TestMultipleValues(t *testing.T) {
+	md := MD{}
+	assert.NoError(t, md.Add("accept", "text/plain"))
+	assert.NoError(t, md.Add("accept", "application/json"))
+	assert.NoError(t, md.Set("tag", "a"))
+
+	v, ok := md.Get("accept")
+	assert.True(t, ok)
+	assert.Equal(t, "text/plain", v)
+	assert.Equal(t, []string{"text/plain", "application/json"}, md.Values("accept"))
+
+	// Clone copies the values.
+	md2 := md.Clone()
+	md2["accept"][0] = "text/html"
+	assert.Equal(t, "text/plain", md.Values("accept")[0])
+
+	data, err := md.Encode()
+	assert.NoError(t, err)
+	decoded := MD{}
+	assert.NoError(t, decoded.Decode(data))
+	assert.Equal(t, md, decoded)
+
+	// Set replaces all the values.
+	assert.NoError(t, md.Set("accept", "*/*"))
+	assert.Equal(t, []string{"*/*"}, md.Values("accept"))
+
+	// repeated keys in the text encoding are multiple values.
+	decoded = MD{}
+	assert.NoError(t, decoded.Decode([]byte("k:1\nk:2")))
+	assert.Equal(t, MD{"k": {"1", "2"}}, decoded)
+
+	// decoding into a non-empty MD replaces the keys decoded rather than appending to them,
+	// so decoding twice gives the same metadata.
+	binaryData, err := MD{"k": {"3"}, "n": {"4"}}.Encode()
+	assert.NoError(t, err)
+	for name, data := range map[string][]byte{"binary": binaryData, "text": []byte("k:3\nn:4")} {
+		decoded = MD{"k": {"1", "2"}, "kept": {"0"}}
+		assert.NoError(t, decoded.Decode(data), name)
+		assert.NoError(t, decoded.Decode(data), name)
+		assert.Equal(t, MD{"k": {"3"}, "n": {"4"}, "kept": {"0"}}, decoded, name)
+	}
+
+	// md is not modified if decoding fails.
+	decoded = MD{"k": {"1"}}
+	assert.Error(t, decoded.DecodeWithLimits([]byte("k:2\na:1\nb:1"), Limits{MaxKeys: 2}))
+	assert.Equal(t, MD{"k": {"1"}}, decoded)
+
+	var fromJSON MD
+	assert.NoError(t, json.Unmarshal([]byte(`{"role":"ingest","zone":["a","b"]}`), &fromJSON))
+	assert.Equal(t, MD{"role": {"ingest"}, "zone": {"a", "b"}}, fromJSON)
+	assert.Error(t, json.Unmarshal([]byte(`{"role":1}`), &fromJSON))
+}
+
+func TestTypedAccessors(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	md := MD{}
+	assert.NoError(t, md.SetInt("retries", -3))
+	assert.NoError(t, md.SetBool("compressed", true))
+	assert.NoError(t, md.SetDuration("ttl", 90*time.Second))
+	assert.NoError(t, md.SetTime("sent_at", now))
+
+	i, err := md.Int("retries")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-3), i)
+
+	b, err := md.Bool("compressed")
+	assert.NoError(t, err)
+	assert.True(t, b)
+
+	d, err := md.Duration("ttl")
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, d)
+
+	ts, err := md.Time("sent_at")
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(ts))
+
+	_, err = md.Int("missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	md.Set("bad", "x")
+	for _, fn := range []func(string) error{
+		func(k string) error { _, err := md.Int(k); return err },
+		func(k string) error { _, err := md.Bool(k); return err },
+		func(k string) error { _, err := md.Duration(k); return err },
+		func(k string) error { _, err := md.Time(k); return err },
+	} {
+		err := fn("bad")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrKeyNotFound)
+	}
+}
+
+func TestContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+
+	md := MD{"trace_id": {"abc"}}
+	got, ok := FromContext(NewContext(context.Background(), md))
+	assert.True(t, ok)
+	assert.Equal(t, md, got)
+}
+
+func TestLimits(t *testing.T) {
+	md := MD{"a": {"1", "2"}, "bb": {"333"}}
+	data, err := md.Encode()
+	assert.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		limits Limits
+		err    error
+	}{
+		"no limit":    {Limits{}, nil},
+		"within":      {Limits{MaxSize: len(data), MaxKeys: 2, MaxValues: 3, MaxKeyLength: 2, MaxValueLength: 3}, nil},
+		"size":        {Limits{MaxSize: len(data) - 1}, ErrTooLarge},
+		"keys":        {Limits{MaxKeys: 1}, ErrTooManyKeys},
+		"values":      {Limits{MaxValues: 2}, ErrTooManyKeys},
+		"key length":  {Limits{MaxKeyLength: 1}, ErrTooLong},
+		"value lengh": {Limits{MaxValueLength: 2}, ErrTooLong},
+	} {
+		if tc.err == nil {
+			assert.NoError(t, tc.limits.Check(md), name)
+			assert.NoError(t, MD{}.DecodeWithLimits(data, tc.limits), name)
+			assert.NoError(t, MD{}.DecodeWithLimits([]byte("a:1\na:2\nbb:333"), tc.limits), name)
+			continue
+		}
+		assert.ErrorIs(t, tc.limits.Check(md), tc.err, name)
+		assert.ErrorIs(t, MD{}.DecodeWithLimits(data, tc.limits), tc.err, name)
+		if name != "size" {
+			assert.ErrorIs(t, MD{}.DecodeWithLimits([]byte("a:1\na:2\nbb:333"), tc.limits), tc.err, name)
+		}
 	}
+
+	// a forged length fails before it is allocated.
+	forged := []byte{0x00, 0x01, 0x01, 0xff, 0xff, 0xff, 0xff, 0x0f}
+	forged = append(forged, make([]byte, 64)...)
+	assert.ErrorIs(t, MD{}.DecodeWithLimits(forged, Limits{MaxKeyLength: 16}), ErrTooLong)
+}
+
+func TestCanonical(t *testing.T) {
+	a := MD{"x": {"1", "2"}, "y": {"3"}, "empty": {}}
+	b := MD{"y": {"3"}}
+	assert.NoError(t, b.Add("x", "1"))
+	assert.NoError(t, b.Add("x", "2"))
+
+	assert.True(t, a.Equal(b))
+	assert.True(t, b.Equal(a))
+	assert.Equal(t, a.Hash(), b.Hash())
+	assert.True(t, MD(nil).Equal(MD{"empty": nil}))
+
+	assert.False(t, a.Equal(MD{"x": {"2", "1"}, "y": {"3"}}))
+	assert.False(t, a.Equal(MD{"x": {"1", "2"}}))
+	assert.False(t, a.Equal(MD{"x": {"1", "2"}, "z": {"3"}}))
+	assert.NotEqual(t, a.Hash(), MD{"x": {"1"}, "y": {"3"}}.Hash())
+	// the values are compared verbatim.
+	assert.False(t, MD{"unit": {"celsius"}}.Equal(MD{"unit": {"Celsius"}}))
+	assert.False(t, MD{"unit": {"celsius"}}.Equal(MD{"unit": {"celsius "}}))
+
+	key := []byte("secret")
+	assert.False(t, a.Verify(key))
+	assert.NoError(t, a.Sign(key))
+	assert.True(t, a.Verify(key))
+	assert.False(t, a.Verify([]byte("other")))
+
+	// the signature survives the encoding.
+	data, err := a.Encode()
+	assert.NoError(t, err)
+	decoded := MD{}
+	assert.NoError(t, decoded.Decode(data))
+	assert.True(t, decoded.Verify(key))
+
+	decoded.Set("y", "4")
+	assert.False(t, decoded.Verify(key))
+
+	// the stream signature is bound to the tag and the stream ID.
+	s := MD{"x": {"1"}}
+	assert.NoError(t, s.SignStream(key, "sensors", "1"))
+	assert.True(t, s.VerifyStream(key, "sensors", "1"))
+	assert.False(t, s.VerifyStream(key, "sensors", "2"))
+	assert.False(t, s.VerifyStream(key, "logs", "1"))
+	assert.False(t, s.VerifyStream(key, "sensors1", ""))
+	assert.False(t, s.VerifyStream([]byte("other"), "sensors", "1"))
+	assert.False(t, s.Verify(key))
+
+	assert.NoError(t, s.Sign(key))
+	assert.False(t, s.VerifyStream(key, "sensors", "1"))
 }
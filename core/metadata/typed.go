@@ -0,0 +1,66 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrKeyNotFound is returned by the typed accessors if the key is not found.
+var ErrKeyNotFound = errors.New("metadata: key not found")
+
+// Int returns the first value of the key as an int64.
+func (md MD) Int(key string) (int64, error) {
+	return parse(md, key, func(s string) (int64, error) { return strconv.ParseInt(s, 10, 64) })
+}
+
+// Bool returns the first value of the key as a bool, It accepts the values accepted by strconv.ParseBool.
+func (md MD) Bool(key string) (bool, error) {
+	return parse(md, key, strconv.ParseBool)
+}
+
+// Duration returns the first value of the key as a time.Duration, like "1m30s".
+func (md MD) Duration(key string) (time.Duration, error) {
+	return parse(md, key, time.ParseDuration)
+}
+
+// Time returns the first value of the key as a time.Time in RFC 3339 format.
+func (md MD) Time(key string) (time.Time, error) {
+	return parse(md, key, func(s string) (time.Time, error) { return time.Parse(time.RFC3339Nano, s) })
+}
+
+// SetInt sets the value of the key to an int64.
+func (md MD) SetInt(key string, value int64) error {
+	return md.Set(key, strconv.FormatInt(value, 10))
+}
+
+// SetBool sets the value of the key to a bool.
+func (md MD) SetBool(key string, value bool) error {
+	return md.Set(key, strconv.FormatBool(value))
+}
+
+// SetDuration sets the value of the key to a time.Duration.
+func (md MD) SetDuration(key string, value time.Duration) error {
+	return md.Set(key, value.String())
+}
+
+// SetTime sets the value of the key to a time.Time in RFC 3339 format.
+func (md MD) SetTime(key string, value time.Time) error {
+	return md.Set(key, value.Format(time.RFC3339Nano))
+}
+
+// parse parses the first value of the key, The error tells whether the key is missing or the value is malformed.
+func parse[T any](md MD, key string, fn func(string) (T, error)) (T, error) {
+	var zero T
+
+	s, ok := md.Get(key)
+	if !ok {
+		return zero, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	v, err := fn(s)
+	if err != nil {
+		return zero, fmt.Errorf("metadata: malformed value of %s: %w", key, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,159 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/woorui/ydesign/core/auth"
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// ReauthOption makes the server reauthenticate the clients periodically,
+// so the clients whose credentials are expired or revoked lose access promptly.
+type ReauthOption struct {
+	// Interval is the interval of reauthentication. The client is also reauthenticated before
+	// its credential expires if the metadata has `auth.ExpiresAtKey`.
+	// If it is 0, the client is reauthenticated only when its credential expires.
+	Interval time.Duration
+	// Timeout is the time that the client has to present fresh credentials.
+	Timeout time.Duration
+}
+
+// deliver delivers the frame answered by client to the reauthentication in progress,
+// the frame is dropped if there is no reauthentication waiting for it.
+func deliver[T frame.Frame](ss *ServerController, ch chan T, f T) {
+	select {
+	case ch <- f:
+	default:
+		ss.logger.Debug("control stream read unexpected frame", "frame_type", f.Type().String())
+	}
+}
+
+// Reauthenticate asks the client to present fresh credentials within the timeout and verifies them,
+// The metadata of the client is replaced once it succeeds, otherwise the connection is closed with
+// `RejectCodeReauthFailed` or the code of the `*RejectedError` returned by verifyFunc.
+func (ss *ServerController) Reauthenticate(verifyFunc VerifyAuthenticationFunc, timeout time.Duration) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	// drop the stale frames.
+	select {
+	case <-ss.authChan:
+	default:
+	}
+
+	if err := ss.writeFrame(&frame.ReauthenticationFrame{Timeout: uint64(timeout.Milliseconds())}); err != nil {
+		return ss.reauthFailed(err)
+	}
+
+	var received *frame.AuthenticationFrame
+	select {
+	case <-ss.conn.Context().Done():
+		return ss.conn.Context().Err()
+	case <-deadline.C:
+		return ss.reauthFailed(errors.New("timeout"))
+	case received = <-ss.authChan:
+	}
+
+	challenge := func(challenge string) (string, error) {
+		if err := ss.writeFrame(&frame.AuthenticationChallengeFrame{Payload: challenge}); err != nil {
+			return "", err
+		}
+		select {
+		case <-ss.conn.Context().Done():
+			return "", ss.conn.Context().Err()
+		case <-deadline.C:
+			return "", errors.New("timeout")
+		case f := <-ss.challengeChan:
+			return f.Payload, nil
+		}
+	}
+
+	md, ok, err := verifyFunc(received, ss.conn.ConnectionState().TLS.ConnectionState, challenge)
+	if rejected := new(RejectedError); errors.As(err, &rejected) {
+		ss.rejectWithCloseConn(rejected.Code, rejected.Message)
+		return err
+	}
+	denied := new(auth.DeniedError)
+	if err != nil && !errors.As(err, &denied) {
+		return ss.reauthFailed(err)
+	}
+
+	if !ok {
+		errString := fmt.Sprintf("reauthentication failed: client credential name is %s", received.AuthName)
+		if denied.Reason != "" {
+			errString = fmt.Sprintf("reauthentication failed: %s", denied.Reason)
+		}
+		ss.rejectWithCloseConn(RejectCodeReauthFailed, errString)
+		return errors.New(errString)
+	}
+
+	ss.mu.Lock()
+	ss.md = md
+	ss.mu.Unlock()
+
+	if err := ss.writeFrame(&frame.AuthenticationAckFrame{ID: ss.id}); err != nil {
+		return ss.reauthFailed(err)
+	}
+	return nil
+}
+
+// reauthFailed closes the connection with `RejectCodeReauthFailed` because of err,
+// so the client is never left authorized with its old metadata.
+func (ss *ServerController) reauthFailed(err error) error {
+	errString := fmt.Sprintf("reauthentication failed: %s", err)
+	ss.rejectWithCloseConn(RejectCodeReauthFailed, errString)
+	return errors.New(errString)
+}
+
+// expiredReauthWait is the time to wait before reauthenticating a client whose credential has expired already,
+// for example, it is accepted within the clock skew, so the client is not reauthenticated in a tight loop.
+const expiredReauthWait = time.Second
+
+// reauthenticateLoop reauthenticates the client of ctrl until the connection is closed or the reauthentication fails.
+func (s *Server) reauthenticateLoop(ctrl *ServerController) {
+	option := s.option.Reauth
+	for {
+		wait, ok := reauthWait(option.Interval, ctrl.Metadata())
+		if !ok {
+			s.logger.Debug("client is not reauthenticated, its credential never expires", "conn_id", ctrl.ID())
+			return
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctrl.conn.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := ctrl.Reauthenticate(s.verifyAuthentication, option.Timeout); err != nil {
+			s.logger.Debug("client reauthentication failed", "conn_id", ctrl.ID(), "error", err)
+			return
+		}
+		s.logger.Debug("client is reauthenticated", "conn_id", ctrl.ID())
+	}
+}
+
+// reauthWait returns the time to wait before the next reauthentication of the client with md, it is the interval
+// or the time before the credential of client expires, whichever comes first. A non-positive interval means
+// no periodic reauthentication, It returns false if the client does not need to be reauthenticated.
+func reauthWait(interval time.Duration, md metadata.MD) (time.Duration, bool) {
+	wait, ok := interval, interval > 0
+
+	if v, found := md.Get(auth.ExpiresAtKey); found {
+		if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+			if untilExpiry := time.Until(time.Unix(sec, 0)); !ok || untilExpiry < wait {
+				wait, ok = untilExpiry, true
+			}
+		}
+	}
+	if ok && wait <= 0 {
+		wait = expiredReauthWait
+	}
+
+	return wait, ok
+}
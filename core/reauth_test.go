@@ -0,0 +1,190 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/auth"
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+	"golang.org/x/exp/slog"
+)
+
+func TestReauthWait(t *testing.T) {
+	expiresAt := func(d time.Duration) metadata.MD {
+		return metadata.MD{auth.ExpiresAtKey: {strconv.FormatInt(time.Now().Add(d).Unix(), 10)}}
+	}
+
+	tests := []struct {
+		name     string
+		interval time.Duration
+		md       metadata.MD
+		ok       bool
+		min, max time.Duration
+	}{
+		{name: "no interval and no expiry", interval: 0, md: nil, ok: false},
+		{name: "negative interval", interval: -time.Second, md: nil, ok: false},
+		{name: "interval", interval: time.Minute, md: nil, ok: true, min: time.Minute, max: time.Minute},
+		{name: "expiry only", interval: 0, md: expiresAt(time.Hour), ok: true, min: time.Hour - 2*time.Second, max: time.Hour},
+		{name: "expiry first", interval: time.Hour, md: expiresAt(time.Minute), ok: true, min: time.Minute - 2*time.Second, max: time.Minute},
+		{name: "interval first", interval: time.Minute, md: expiresAt(time.Hour), ok: true, min: time.Minute, max: time.Minute},
+		{name: "expired", interval: 0, md: expiresAt(-time.Hour), ok: true, min: expiredReauthWait, max: expiredReauthWait},
+		{name: "invalid expiry", interval: 0, md: metadata.MD{auth.ExpiresAtKey: {"never"}}, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, ok := reauthWait(tt.interval, tt.md)
+			assert.Equal(t, tt.ok, ok)
+			if ok {
+				assert.GreaterOrEqual(t, wait, tt.min)
+				assert.LessOrEqual(t, wait, tt.max)
+			}
+		})
+	}
+}
+
+func TestReauthenticate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	registry := auth.NewRegistry()
+	registry.Register(auth.NewTokenAuth(auth.Token{Token: "secret"}))
+	addr := listenServer(t, ctx, &ServerOption{
+		Auth:   registry,
+		Reauth: &ReauthOption{Interval: 50 * time.Millisecond, Timeout: time.Second},
+	})
+
+	client := openTestClient(t, ctx, addr, WithCredential("token:secret"))
+	_, errCh := observe(client, "sensors")
+
+	// the client answers the reauthentications with its credential.
+	select {
+	case err := <-errCh:
+		t.Fatalf("the client is closed: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// the client is closed once its credential is revoked.
+	registry.Unregister("token")
+	select {
+	case err := <-errCh:
+		assert.ErrorIs(t, err, RejectCodeReauthFailed)
+	case <-ctx.Done():
+		t.Fatal("the client is not reauthenticated")
+	}
+}
+
+func TestReauthenticateExpiryOnly(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	expiresAt := strconv.FormatInt(time.Now().Add(time.Second).Unix(), 10)
+	registry := auth.NewRegistry()
+	registry.Register(auth.NewTokenAuth(
+		auth.Token{Token: "forever"},
+		auth.Token{Token: "expiring", Metadata: metadata.MD{auth.ExpiresAtKey: {expiresAt}}},
+	))
+	addr := listenServer(t, ctx, &ServerOption{Auth: registry, Reauth: &ReauthOption{Timeout: time.Second}})
+
+	forever := openTestClient(t, ctx, addr, WithCredential("token:forever"))
+	expiring := openTestClient(t, ctx, addr, WithCredential("token:expiring"))
+	_, foreverErr := observe(forever, "sensors")
+	_, expiringErr := observe(expiring, "sensors")
+	registry.Unregister("token")
+
+	// the client is reauthenticated only when its credential expires.
+	select {
+	case err := <-expiringErr:
+		assert.ErrorIs(t, err, RejectCodeReauthFailed)
+	case <-ctx.Done():
+		t.Fatal("the client is not reauthenticated")
+	}
+	assert.Empty(t, foreverErr)
+}
+
+func TestReauthenticateError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ln, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}, NextProtos: []string{ALPN}}, nil)
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	frw := NewFrameReadWriter(frame.NewPacketCodec(0), frame.NewCodec())
+	client := dialIdle(t, ctx, ln.Addr().String())
+	stream, err := client.OpenStreamSync(ctx)
+	assert.NoError(t, err)
+	// the stream is accepted once the client writes to it.
+	assert.NoError(t, frw.WriteFrame(stream, &frame.AuthenticationChallengeFrame{}))
+
+	conn, err := ln.Accept(ctx)
+	assert.NoError(t, err)
+	stream0, err := conn.AcceptStream(ctx)
+	assert.NoError(t, err)
+	ctrl := NewServerController(ctx, conn, stream0, frw, slog.Default(), randomID)
+	go ctrl.readFrameLoop()
+
+	// the error of verifying closes the connection, so the client is not left with its old metadata.
+	verify := func(*frame.AuthenticationFrame, tls.ConnectionState, auth.ChallengeFunc) (metadata.MD, bool, error) {
+		return nil, false, errors.New("registry is broken")
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- ctrl.Reauthenticate(verify, time.Second) }()
+
+	f, err := frw.Readframe(stream)
+	assert.NoError(t, err)
+	assert.IsType(t, &frame.ReauthenticationFrame{}, f)
+	assert.NoError(t, frw.WriteFrame(stream, &frame.AuthenticationFrame{AuthName: "token", AuthPayload: "secret"}))
+
+	assert.ErrorContains(t, <-errCh, "registry is broken")
+	_, err = client.AcceptStream(ctx)
+	assert.ErrorIs(t, asRejectedError(err), RejectCodeReauthFailed)
+}
+
+func TestReauthenticateCredentialFunc(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	registry := auth.NewRegistry()
+	registry.Register(auth.NewTokenAuth(auth.Token{Token: "first"}))
+	addr := listenServer(t, ctx, &ServerOption{
+		Auth:   registry,
+		Reauth: &ReauthOption{Interval: 50 * time.Millisecond, Timeout: time.Second},
+	})
+
+	var (
+		mu    sync.Mutex
+		calls int
+	)
+	credential := func() (auth.Credential, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+		if calls == 1 {
+			return auth.NewCredential("token:first"), nil
+		}
+		return auth.NewCredential("token:second"), nil
+	}
+	client := openTestClient(t, ctx, addr, WithCredentialFunc(credential))
+	_, errCh := observe(client, "sensors")
+
+	// the token is rotated, the client answers the reauthentications with the fresh credential.
+	registry.Register(auth.NewTokenAuth(auth.Token{Token: "second"}))
+	select {
+	case err := <-errCh:
+		t.Fatalf("the client is closed: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	mu.Lock()
+	assert.Greater(t, calls, 2)
+	mu.Unlock()
+}
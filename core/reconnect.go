@@ -0,0 +1,464 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ConnState is the state of the connection of a reconnecting client.
+type ConnState int
+
+const (
+	// ConnStateConnecting means the client is dialing the server for the first time.
+	ConnStateConnecting ConnState = iota
+	// ConnStateReady means the client is authenticated and the observed tags are requested.
+	ConnStateReady
+	// ConnStateReconnecting means the connection is lost and the client is dialing the server again.
+	ConnStateReconnecting
+	// ConnStateClosed means the client is closed or gives up reconnecting, It is the final state.
+	ConnStateClosed
+)
+
+// String returns the name of the state.
+func (s ConnState) String() string {
+	switch s {
+	case ConnStateConnecting:
+		return "connecting"
+	case ConnStateReady:
+		return "ready"
+	case ConnStateReconnecting:
+		return "reconnecting"
+	case ConnStateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("ConnState(%d)", int(s))
+	}
+}
+
+var (
+	// ErrReconnecting is returned when opening a stream while the client is reconnecting.
+	ErrReconnecting = errors.New("client: the connection is reconnecting")
+	// ErrClientClosed is returned when using a closed client.
+	ErrClientClosed = errors.New("client: the client is closed")
+)
+
+// dialController dials the server at addr and authenticates with the credential of option,
+// The reauthentications of server are answered with the fresh credentials of `ClientOption.CredentialFunc` if it is set.
+func dialController(ctx context.Context, addr string, option *ClientOption) (ClientController, error) {
+	cred, err := option.credential()
+	if err != nil {
+		return nil, err
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, option.DialTimeout)
+	defer cancel()
+
+	controller, err := NewClientControlStreamOpener(option).Open(dialCtx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if option.CredentialFunc != nil {
+		controller.OnReauthenticate(option.CredentialFunc)
+	}
+	if err := controller.Authenticate(cred); err != nil {
+		controller.CloseWithError(err.Error())
+		return nil, err
+	}
+
+	return controller, nil
+}
+
+// reconnectable reports whether the client should reconnect after the connection is closed by err,
+// The client does not reconnect if the server rejects it permanently, for example, the credential is rejected
+// or another client takes over its name.
+func reconnectable(err error) bool {
+	rerr := new(RejectedError)
+	if !errors.As(err, &rerr) {
+		return true
+	}
+	return rerr.Temporary() || rerr.Code == RejectCodeClosed
+}
+
+// reconnectingConn is the UniStreamPeerConnection that redials the server when the connection is lost,
+// It authenticates again and requests the observed tags again on the new connection.
+type reconnectingConn struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	addr   string
+	option *ClientOption
+	dial   dialFunc
+
+	// mu guards the fields below and the replaying of the observed tags.
+	mu sync.Mutex
+	// ctrl is the current connection, it is nil while reconnecting.
+	ctrl ClientController
+	// id is the ID of the last connection, it is kept while reconnecting.
+	id    string
+	state ConnState
+	// err is the reason why the client is closed.
+	err error
+	// changed is closed and replaced when the state changes.
+	changed chan struct{}
+	// tags is the observed tags in the order of requesting.
+	tags    []string
+	tagsSet map[string]struct{}
+	// onObserveRejected is called when the server rejects a request to observe a tag.
+	onObserveRejected func(tag string, err *RejectedError)
+	// onStreamAcked is called when the server acknowledges a stream opened.
+	onStreamAcked func(id string, err *RejectedError)
+}
+
+// dialFunc dials the server and returns the authenticated controller, It is `dialController` except in tests.
+type dialFunc func(ctx context.Context, addr string, option *ClientOption) (ClientController, error)
+
+// openReconnectingConn dials the server and returns the connection that reconnects when it is lost,
+// The first dialing is not retried, so that a wrong address or credential fails fast.
+func openReconnectingConn(ctx context.Context, addr string, option *ClientOption, dial dialFunc) (*reconnectingConn, error) {
+	// the client outlives ctx, ctx only limits the first dialing.
+	life, cancel := context.WithCancel(context.Background())
+
+	rc := &reconnectingConn{
+		ctx:     life,
+		cancel:  cancel,
+		addr:    addr,
+		option:  option,
+		dial:    dial,
+		state:   ConnStateConnecting,
+		changed: make(chan struct{}),
+		tagsSet: make(map[string]struct{}),
+	}
+	rc.notify(ConnStateConnecting, nil)
+
+	ctrl, err := rc.dial(ctx, addr, option)
+	if err != nil {
+		cancel()
+		rc.setClosed(err)
+		return nil, err
+	}
+	if err := rc.install(ctrl); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go rc.supervise(ctrl)
+
+	return rc, nil
+}
+
+// supervise waits for ctrl to be closed and reconnects, until the client is closed or gives up.
+func (rc *reconnectingConn) supervise(ctrl ClientController) {
+	for {
+		select {
+		case <-ctrl.Done():
+		case <-rc.ctx.Done():
+			return
+		}
+		if rc.ctx.Err() != nil {
+			return
+		}
+
+		cause := ctrl.Err()
+		if !reconnectable(cause) {
+			rc.option.Logger.Debug("client stops reconnecting", "conn_id", ctrl.ID(), "err", cause)
+			rc.setClosed(cause)
+			return
+		}
+		rc.setReconnecting(cause)
+		rc.option.Logger.Debug("client connection is lost", "conn_id", ctrl.ID(), "err", cause)
+
+		next, err := rc.redial()
+		if err != nil {
+			rc.setClosed(err)
+			return
+		}
+		ctrl = next
+	}
+}
+
+// redial dials the server with backoff until it succeeds, the client is closed or the attempts run out.
+func (rc *reconnectingConn) redial() (ClientController, error) {
+	var (
+		option  = rc.option.Reconnect
+		lastErr error
+	)
+	for attempt := 0; option.MaxAttempts == 0 || attempt < option.MaxAttempts; attempt++ {
+		timer := time.NewTimer(option.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-rc.ctx.Done():
+			timer.Stop()
+			return nil, ErrClientClosed
+		}
+
+		ctrl, err := rc.dial(rc.ctx, rc.addr, rc.option)
+		if err == nil {
+			if err = rc.install(ctrl); err == nil {
+				return ctrl, nil
+			}
+		}
+		if errors.Is(err, ErrClientClosed) || !reconnectable(err) {
+			return nil, err
+		}
+		rc.option.Logger.Debug("client failed to reconnect", "attempt", attempt+1, "err", err)
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("client: gave up reconnecting after %d attempts: %w", option.MaxAttempts, lastErr)
+}
+
+// install requests the observed tags on ctrl and makes it the current connection.
+func (rc *reconnectingConn) install(ctrl ClientController) error {
+	rc.mu.Lock()
+
+	if rc.state == ConnStateClosed {
+		rc.mu.Unlock()
+		ctrl.Close()
+		return ErrClientClosed
+	}
+	ctrl.OnObserveRejected(rc.observeRejected)
+	ctrl.OnStreamAcked(rc.streamAcked)
+	// request the tags in the lock, so that the tags requested meanwhile are not missed.
+	for _, tag := range rc.tags {
+		if err := ctrl.RequestObserve(tag); err != nil {
+			rc.mu.Unlock()
+			ctrl.CloseWithError(err.Error())
+			return err
+		}
+	}
+	rc.ctrl = ctrl
+	rc.id = ctrl.ID()
+	rc.changeState(ConnStateReady, nil)
+	observed := len(rc.tags)
+
+	rc.mu.Unlock()
+
+	rc.option.Logger.Debug("client is ready", "conn_id", ctrl.ID(), "observed", observed)
+	rc.notify(ConnStateReady, nil)
+
+	return nil
+}
+
+func (rc *reconnectingConn) setReconnecting(err error) {
+	rc.mu.Lock()
+	if rc.state == ConnStateClosed {
+		rc.mu.Unlock()
+		return
+	}
+	rc.ctrl = nil
+	rc.changeState(ConnStateReconnecting, nil)
+	rc.mu.Unlock()
+
+	rc.notify(ConnStateReconnecting, err)
+}
+
+// setClosed closes the client with the reason err, err is nil if it is closed by user.
+func (rc *reconnectingConn) setClosed(err error) {
+	rc.mu.Lock()
+	if rc.state == ConnStateClosed {
+		rc.mu.Unlock()
+		return
+	}
+	ctrl := rc.ctrl
+	rc.ctrl = nil
+	rc.changeState(ConnStateClosed, err)
+	rc.mu.Unlock()
+
+	if ctrl != nil {
+		ctrl.Close()
+	}
+	rc.notify(ConnStateClosed, err)
+}
+
+// changeState changes the state and wakes up the waiters, it must be called with mu held.
+func (rc *reconnectingConn) changeState(state ConnState, err error) {
+	rc.state = state
+	rc.err = err
+	close(rc.changed)
+	rc.changed = make(chan struct{})
+}
+
+func (rc *reconnectingConn) notify(state ConnState, err error) {
+	if rc.option.OnStateChange != nil {
+		rc.option.OnStateChange(state, err)
+	}
+}
+
+// current returns the current connection and the channel closed at the next change,
+// The connection is nil while reconnecting, and the error is not nil if the client is closed.
+func (rc *reconnectingConn) current() (ClientController, <-chan struct{}, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.state == ConnStateClosed {
+		if rc.err != nil {
+			return nil, nil, rc.err
+		}
+		return nil, nil, ErrClientClosed
+	}
+	return rc.ctrl, rc.changed, nil
+}
+
+// ready waits until the client is connected, It returns the error if the client is closed.
+func (rc *reconnectingConn) ready(ctx context.Context) error {
+	for {
+		ctrl, changed, err := rc.current()
+		if err != nil {
+			return err
+		}
+		if ctrl != nil {
+			select {
+			case <-ctrl.Done():
+				// the connection is lost but the supervisor has not noticed it yet.
+			default:
+				return nil
+			}
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ID returns the ID of the current connection, It changes after reconnecting.
+func (rc *reconnectingConn) ID() string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.id
+}
+
+// State returns the state of the connection.
+func (rc *reconnectingConn) State() ConnState {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.state
+}
+
+// OpenUniStream opens a uniStream on the current connection, It returns ErrReconnecting while reconnecting.
+func (rc *reconnectingConn) OpenUniStream() (WriteStream, error) {
+	ctrl, _, err := rc.current()
+	if err != nil {
+		return nil, err
+	}
+	if ctrl == nil {
+		return nil, ErrReconnecting
+	}
+	return ctrl.OpenUniStream()
+}
+
+// AcceptUniStream accepts a uniStream, It waits for the next connection if the current one is lost,
+// so the observing survives the reconnections.
+func (rc *reconnectingConn) AcceptUniStream(ctx context.Context) (ReadStream, error) {
+	var lost ClientController
+	for {
+		ctrl, changed, err := rc.current()
+		if err != nil {
+			return nil, err
+		}
+		if ctrl == nil || ctrl == lost {
+			select {
+			case <-changed:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		r, err := ctrl.AcceptUniStream(ctx)
+		if err == nil {
+			return r, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		lost = ctrl
+	}
+}
+
+// RequestObserve requests server to observe the tag, The tag is requested again after reconnecting,
+// and it is requested on the next connection if the client is reconnecting.
+func (rc *reconnectingConn) RequestObserve(tag string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.state == ConnStateClosed {
+		return ErrClientClosed
+	}
+	if _, ok := rc.tagsSet[tag]; !ok {
+		rc.tagsSet[tag] = struct{}{}
+		rc.tags = append(rc.tags, tag)
+	}
+	if rc.ctrl == nil {
+		return nil
+	}
+	if err := rc.ctrl.RequestObserve(tag); err != nil {
+		select {
+		case <-rc.ctrl.Done():
+			// the connection is lost, the tag is requested after reconnecting.
+			return nil
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// OnObserveRejected sets the function called when the server rejects a request to observe a tag,
+// The tag rejected is not requested again after reconnecting.
+func (rc *reconnectingConn) OnObserveRejected(fn func(tag string, err *RejectedError)) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.onObserveRejected = fn
+}
+
+func (rc *reconnectingConn) observeRejected(tag string, err *RejectedError) {
+	rc.mu.Lock()
+	if _, ok := rc.tagsSet[tag]; ok {
+		delete(rc.tagsSet, tag)
+		for i, t := range rc.tags {
+			if t == tag {
+				rc.tags = append(rc.tags[:i], rc.tags[i+1:]...)
+				break
+			}
+		}
+	}
+	fn := rc.onObserveRejected
+	rc.mu.Unlock()
+
+	if fn != nil {
+		fn(tag, err)
+	}
+}
+
+// OnStreamAcked sets the function called when the server acknowledges a stream opened on any connection.
+func (rc *reconnectingConn) OnStreamAcked(fn func(id string, err *RejectedError)) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.onStreamAcked = fn
+}
+
+func (rc *reconnectingConn) streamAcked(id string, err *RejectedError) {
+	rc.mu.Lock()
+	fn := rc.onStreamAcked
+	rc.mu.Unlock()
+
+	if fn != nil {
+		fn(id, err)
+	}
+}
+
+// Close closes the client and stops reconnecting.
+func (rc *reconnectingConn) Close() error {
+	rc.cancel()
+	rc.setClosed(nil)
+	return nil
+}
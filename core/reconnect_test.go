@@ -0,0 +1,359 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/auth"
+	"github.com/woorui/ydesign/core/frame"
+)
+
+func TestReconnect(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		ctrls  []*fakeController
+		states []ConnState
+		fail   = 1 // the first reconnection fails.
+	)
+	dial := func(ctx context.Context, addr string, option *ClientOption) (ClientController, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(ctrls) > 0 && fail > 0 {
+			fail--
+			return nil, errors.New("network is unreachable")
+		}
+		ctrl := newFakeController(string(rune('a' + len(ctrls))))
+		ctrls = append(ctrls, ctrl)
+		return ctrl, nil
+	}
+	last := func() *fakeController {
+		mu.Lock()
+		defer mu.Unlock()
+		return ctrls[len(ctrls)-1]
+	}
+
+	option, err := NewClientOption(
+		WithReconnect(&ReconnectOption{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}),
+		WithStateHandler(func(state ConnState, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, state)
+		}),
+	)
+	assert.NoError(t, err)
+
+	rc, err := openReconnectingConn(context.Background(), "localhost:9000", option, dial)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", rc.ID())
+
+	assert.NoError(t, rc.RequestObserve("sensors"))
+	assert.NoError(t, rc.RequestObserve("logs"))
+	assert.NoError(t, rc.RequestObserve("sensors"))
+
+	// the observing waits for the next connection.
+	accepted := make(chan error)
+	go func() {
+		_, err := rc.AcceptUniStream(context.Background())
+		accepted <- err
+	}()
+
+	first := last()
+	first.lose(errors.New("timeout: no recent network activity"))
+
+	assert.Eventually(t, func() bool { return rc.State() == ConnStateReady && rc.ID() == "b" }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"sensors", "logs"}, last().observedTags())
+
+	last().lose(&RejectedError{Code: RejectCodeTakenOver})
+	assert.ErrorIs(t, <-accepted, RejectCodeTakenOver)
+	assert.Equal(t, ConnStateClosed, rc.State())
+
+	_, err = rc.OpenUniStream()
+	assert.ErrorIs(t, err, RejectCodeTakenOver)
+
+	mu.Lock()
+	assert.Equal(t, []ConnState{ConnStateConnecting, ConnStateReady, ConnStateReconnecting, ConnStateReady, ConnStateClosed}, states)
+	mu.Unlock()
+}
+
+func TestReconnectObserveRejected(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		ctrls []*fakeController
+	)
+	dial := func(ctx context.Context, addr string, option *ClientOption) (ClientController, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		ctrl := newFakeController(string(rune('a' + len(ctrls))))
+		ctrls = append(ctrls, ctrl)
+		return ctrl, nil
+	}
+	last := func() *fakeController {
+		mu.Lock()
+		defer mu.Unlock()
+		return ctrls[len(ctrls)-1]
+	}
+
+	option, err := NewClientOption(WithReconnect(&ReconnectOption{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}))
+	assert.NoError(t, err)
+	rc, err := openReconnectingConn(context.Background(), "localhost:9000", option, dial)
+	assert.NoError(t, err)
+	client, err := NewClient(rc, option)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	assert.NoError(t, rc.RequestObserve("sensors"))
+
+	// the rejection is returned to the caller observing the tag.
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- client.Observe("secret", ObserveHandleFunc(func(WriterOpener, *StreamHeader, ReadStream) {}))
+	}()
+	assert.Eventually(t, func() bool { return len(last().observedTags()) == 2 }, time.Second, time.Millisecond)
+	last().rejectObserve("secret", RejectCodeForbidden)
+
+	select {
+	case err := <-errCh:
+		assert.ErrorIs(t, err, RejectCodeForbidden)
+	case <-time.After(time.Second):
+		t.Fatal("the rejection is not returned")
+	}
+
+	// the tag rejected is not requested again after reconnecting.
+	first := last()
+	first.lose(errors.New("timeout: no recent network activity"))
+	assert.Eventually(t, func() bool { return rc.State() == ConnStateReady && last() != first }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"sensors"}, last().observedTags())
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	option := &ReconnectOption{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, Jitter: 0.5}
+
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		for i := 0; i < 10; i++ {
+			d := option.backoff(attempt)
+			assert.GreaterOrEqual(t, d, want/2)
+			assert.LessOrEqual(t, d, want*3/2)
+		}
+	}
+
+	assert.True(t, reconnectable(errors.New("timeout")))
+	assert.True(t, reconnectable(&RejectedError{Code: RejectCodeGoingAway}))
+	assert.False(t, reconnectable(&RejectedError{Code: RejectCodeAuthFailed}))
+}
+
+type fakeController struct {
+	id   string
+	done chan struct{}
+
+	mu         sync.Mutex
+	err        error
+	observed   []string
+	streams    []*fakeStream
+	onRejected func(tag string, err *RejectedError)
+	onAcked    func(id string, err *RejectedError)
+	// closeErr is returned by the next Close of the streams.
+	closeErr error
+	// noAck stops acknowledging the streams closed, like the server without observers.
+	noAck bool
+}
+
+func newFakeController(id string) *fakeController {
+	return &fakeController{id: id, done: make(chan struct{})}
+}
+
+func (c *fakeController) lose(err error) {
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+	close(c.done)
+}
+
+func (c *fakeController) failClose(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeErr = err
+}
+
+func (c *fakeController) observedTags() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.observed...)
+}
+
+func (c *fakeController) ID() string { return c.id }
+
+func (c *fakeController) OpenUniStream() (WriteStream, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	stream := &fakeStream{conn: c}
+	c.streams = append(c.streams, stream)
+	return stream, nil
+}
+
+func (c *fakeController) openedStreams() []*fakeStream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*fakeStream(nil), c.streams...)
+}
+
+// fakeStream is the WriteStream of fakeController, It fails after the controller is lost.
+type fakeStream struct {
+	conn   *fakeController
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (s *fakeStream) Write(p []byte) (int, error) {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+
+	if s.conn.err != nil {
+		return 0, s.conn.err
+	}
+	return s.buf.Write(p)
+}
+
+func (s *fakeStream) Close() error {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+
+	if err := s.conn.closeErr; err != nil {
+		s.conn.closeErr = nil
+		return err
+	}
+	s.closed = true
+	if fn := s.conn.onAcked; fn != nil && !s.conn.noAck {
+		header, err := readStreamHeader(NewFrameReadWriter(frame.NewPacketCodec(0), frame.NewCodec()), bytes.NewReader(s.buf.Bytes()))
+		if err == nil {
+			go fn(header.ID, nil)
+		}
+	}
+	return nil
+}
+
+func (s *fakeStream) CancelWrite(quic.StreamErrorCode) {}
+
+// data returns the data written after the header, and whether the stream is closed.
+func (s *fakeStream) data(frw *FrameReadWriter) (*StreamHeader, string, bool) {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+
+	r := bytes.NewReader(s.buf.Bytes())
+	header, err := readStreamHeader(frw, r)
+	if err != nil {
+		return nil, "", s.closed
+	}
+	rest, _ := io.ReadAll(r)
+	return header, string(rest), s.closed
+}
+
+func (c *fakeController) AcceptUniStream(ctx context.Context) (ReadStream, error) {
+	select {
+	case <-c.done:
+		return nil, c.Err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *fakeController) RequestObserve(tag string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.observed = append(c.observed, tag)
+	return nil
+}
+
+func (c *fakeController) Authenticate(auth.Credential) error               { return nil }
+func (c *fakeController) OnReauthenticate(func() (auth.Credential, error)) {}
+func (c *fakeController) Done() <-chan struct{}                            { return c.done }
+
+func (c *fakeController) OnObserveRejected(fn func(tag string, err *RejectedError)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onRejected = fn
+}
+
+func (c *fakeController) OnStreamAcked(fn func(id string, err *RejectedError)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onAcked = fn
+}
+
+// stopAcking stops acknowledging the streams closed.
+func (c *fakeController) stopAcking() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.noAck = true
+}
+
+// rejectObserve rejects the request to observe tag like the server.
+func (c *fakeController) rejectObserve(tag string, code RejectCode) {
+	c.mu.Lock()
+	fn := c.onRejected
+	c.mu.Unlock()
+	fn(tag, &RejectedError{Code: code})
+}
+
+func (c *fakeController) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *fakeController) Close() error                { return nil }
+func (c *fakeController) CloseWithError(string) error { return nil }
+
+func TestReconnectServerRestart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	serverCtx, closeServer := context.WithCancel(ctx)
+	addr := listenServer(t, serverCtx, nil)
+
+	states := make(chan ConnState, 16)
+	client := openTestClient(t, ctx, addr,
+		WithReconnect(&ReconnectOption{InitialBackoff: 50 * time.Millisecond, Jitter: NoReconnectJitter}),
+		WithStateHandler(func(state ConnState, _ error) { states <- state }),
+	)
+	assert.Equal(t, float64(NoReconnectJitter), client.option.Reconnect.Jitter)
+	streams, errCh := observe(client, "sensors")
+	time.Sleep(100 * time.Millisecond)
+
+	// the server restarts, the client reconnects and observes the tag again.
+	closeServer()
+	listenServerAt(t, ctx, addr, nil)
+
+	// skip the states of the first connection.
+	for _, want := range []ConnState{ConnStateReconnecting, ConnStateReady} {
+		for state := ConnState(-1); state != want; {
+			select {
+			case state = <-states:
+			case <-ctx.Done():
+				t.Fatalf("the client does not change to %s", want)
+			}
+		}
+	}
+
+	w, err := openTestClient(t, ctx, addr).Open("sensors", nil)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("21.5"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	assert.Equal(t, "21.5", string(receive(t, streams).data))
+	assert.Empty(t, errCh)
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/quic-go/quic-go"
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// RejectCode is the code of a rejection from the peer, It is used as the code of RejectedFrame,
+// quic.ApplicationErrorCode when closing the connection and quic.StreamErrorCode when canceling a stream,
+// so the same rejection has the same code wherever it is received.
+//
+// RejectCode is an error, `errors.Is(err, RejectCodeForbidden)` reports whether err is a forbidden rejection.
+type RejectCode uint64
+
+const (
+	// RejectCodeClosed means the connection is closed normally.
+	RejectCodeClosed RejectCode = 0
+	// RejectCodeAuthFailed means the credential of client is rejected.
+	RejectCodeAuthFailed RejectCode = 223
+	// RejectCodeUnexpectedFrame means the peer receives an unexpected frame.
+	RejectCodeUnexpectedFrame RejectCode = 224
+	// RejectCodeForbidden means the client is not allowed to publish or observe a tag.
+	RejectCodeForbidden RejectCode = 225
+	// RejectCodeLimitExceeded means the client exceeds the limits of its tenant.
+	RejectCodeLimitExceeded RejectCode = 226
+	// RejectCodeReauthFailed means the client fails the reauthentication or does not answer it in time.
+	RejectCodeReauthFailed RejectCode = 227
+	// RejectCodeHandshakeTimeout means the client does not complete the authentication in time.
+	RejectCodeHandshakeTimeout RejectCode = 228
+	// RejectCodeOverloaded means too many clients are authenticating.
+	RejectCodeOverloaded RejectCode = 229
+	// RejectCodeTooManyFailures means the address of client fails the authentication too many times.
+	RejectCodeTooManyFailures RejectCode = 230
+	// RejectCodeUnsupportedVersion means the peer does not support the version of protocol,
+	// for example, the encoding version of the stream metadata.
+	RejectCodeUnsupportedVersion RejectCode = 231
+	// RejectCodeGoingAway means the server is shutting down.
+	RejectCodeGoingAway RejectCode = 232
+	// RejectCodeDuplicateName means another connected client has the same name.
+	RejectCodeDuplicateName RejectCode = 233
+	// RejectCodeTakenOver means the session is taken over by a new connection with the same name.
+	RejectCodeTakenOver RejectCode = 234
+	// RejectCodeMetadataTooLarge means the encoded metadata exceeds the size limit.
+	RejectCodeMetadataTooLarge RejectCode = 235
+	// RejectCodeMetadataTooManyKeys means the metadata exceeds the limit of keys or values.
+	RejectCodeMetadataTooManyKeys RejectCode = 236
+	// RejectCodeMetadataTooLong means a key or value of the metadata exceeds the length limit.
+	RejectCodeMetadataTooLong RejectCode = 237
+	// RejectCodeCanceled means the stream is canceled because its other side is lost,
+	// for example, the connection of the writer or the observer is closed.
+	RejectCodeCanceled RejectCode = 238
+	// RejectCodeNotObserved means the client receives a stream of the tag that it does not observe.
+	RejectCodeNotObserved RejectCode = 239
+)
+
+var rejectCodeStrings = map[RejectCode]string{
+	RejectCodeClosed:              "closed",
+	RejectCodeAuthFailed:          "authentication failed",
+	RejectCodeUnexpectedFrame:     "unexpected frame",
+	RejectCodeForbidden:           "forbidden",
+	RejectCodeLimitExceeded:       "limit exceeded",
+	RejectCodeReauthFailed:        "reauthentication failed",
+	RejectCodeHandshakeTimeout:    "handshake timeout",
+	RejectCodeOverloaded:          "overloaded",
+	RejectCodeTooManyFailures:     "too many failures",
+	RejectCodeUnsupportedVersion:  "unsupported version",
+	RejectCodeGoingAway:           "going away",
+	RejectCodeDuplicateName:       "duplicate name",
+	RejectCodeTakenOver:           "taken over",
+	RejectCodeMetadataTooLarge:    "metadata too large",
+	RejectCodeMetadataTooManyKeys: "metadata has too many keys",
+	RejectCodeMetadataTooLong:     "metadata key or value too long",
+	RejectCodeCanceled:            "canceled",
+	RejectCodeNotObserved:         "not observed",
+}
+
+// temporaryRejectCodes is the codes of the rejections that may succeed if retried later.
+var temporaryRejectCodes = map[RejectCode]bool{
+	RejectCodeLimitExceeded:    true,
+	RejectCodeHandshakeTimeout: true,
+	RejectCodeOverloaded:       true,
+	RejectCodeTooManyFailures:  true,
+	RejectCodeGoingAway:        true,
+	// the old session may be stale and gone later.
+	RejectCodeDuplicateName: true,
+	// the stream is lost with its peer, not rejected.
+	RejectCodeCanceled: true,
+	// the tag may be observed again.
+	RejectCodeNotObserved: true,
+}
+
+// String returns a human-readable string which represents the RejectCode.
+func (c RejectCode) String() string {
+	if s, ok := rejectCodeStrings[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown rejection %d", uint64(c))
+}
+
+// Error implements the error interface, It is the same as String.
+func (c RejectCode) Error() string { return c.String() }
+
+// Temporary reports whether the rejection may succeed if retried later,
+// Retrying a permanent rejection, like authentication failed, never succeeds.
+func (c RejectCode) Temporary() bool { return temporaryRejectCodes[c] }
+
+// ApplicationErrorCode returns the code used to close the connection.
+func (c RejectCode) ApplicationErrorCode() quic.ApplicationErrorCode {
+	return quic.ApplicationErrorCode(c)
+}
+
+// StreamErrorCode returns the code used to cancel a stream.
+func (c RejectCode) StreamErrorCode() quic.StreamErrorCode {
+	return quic.StreamErrorCode(c)
+}
+
+// RejectedError is returned when the client is rejected by the server,
+// It unwraps to its RejectCode, so both `errors.Is` and `errors.As` work with it.
+type RejectedError struct {
+	Code    RejectCode
+	Message string
+}
+
+// Error returns the message from the peer.
+func (e *RejectedError) Error() string {
+	if e.Message == "" {
+		return e.Code.String()
+	}
+	return e.Message
+}
+
+// Unwrap returns the code of the rejection.
+func (e *RejectedError) Unwrap() error { return e.Code }
+
+// Temporary reports whether the rejection may succeed if retried later.
+func (e *RejectedError) Temporary() bool { return e.Code.Temporary() }
+
+// metadataRejectCode returns the code of the error returned when metadata exceeds metadata.Limits
+// or it is encoded in an unsupported version.
+func metadataRejectCode(err error) (RejectCode, bool) {
+	switch {
+	case errors.Is(err, metadata.ErrUnsupportedVersion):
+		return RejectCodeUnsupportedVersion, true
+	case errors.Is(err, metadata.ErrTooLarge), errors.Is(err, frame.ErrPacketTooLarge):
+		return RejectCodeMetadataTooLarge, true
+	case errors.Is(err, metadata.ErrTooManyKeys):
+		return RejectCodeMetadataTooManyKeys, true
+	case errors.Is(err, metadata.ErrTooLong):
+		return RejectCodeMetadataTooLong, true
+	}
+	return 0, false
+}
+
+// asRejectedError converts the connection or stream error closed or canceled by the peer to a *RejectedError,
+// Other errors are returned as they are.
+func asRejectedError(err error) error {
+	if qerr := new(quic.ApplicationError); errors.As(err, &qerr) && qerr.Remote {
+		return &RejectedError{Code: RejectCode(qerr.ErrorCode), Message: qerr.ErrorMessage}
+	}
+	if serr := new(quic.StreamError); errors.As(err, &serr) && serr.Remote {
+		return &RejectedError{Code: RejectCode(serr.ErrorCode)}
+	}
+	return err
+}
@@ -3,7 +3,12 @@ package core
 import (
 	"context"
 	"io"
+	"sync"
 
+	"github.com/quic-go/quic-go"
+	"github.com/woorui/ydesign/core/acl"
+	"github.com/woorui/ydesign/core/auth"
+	"github.com/woorui/ydesign/core/metadata"
 	"golang.org/x/exp/slog"
 )
 
@@ -14,24 +19,79 @@ type Server struct {
 	frw       *FrameReadWriter
 
 	readerChan   chan taggedReader
-	readEOFChan  chan string // if read EOF, send to this chan
 	observerChan chan taggedConnection
+	// unobserveChan receives the ID of the connection that stops observing.
+	unobserveChan chan string
 
-	logger *slog.Logger
+	option    *ServerOption
+	tenants   *tenants
+	handshake *handshake
+	names     *names
+	// controllers stores the authenticated clients by ID, they are told that the server is going away when it is closed.
+	controllers sync.Map
+	goingAway   sync.Once
+	logger      *slog.Logger
+}
+
+// ServerOption is the option to create a server.
+type ServerOption struct {
+	// Auth authenticates clients, Each server can have its own registry and
+	// the authentications in it can be replaced at runtime. It is an empty registry that
+	// denies every client if nil, the package-level registry `auth.Default()` is never used implicitly.
+	Auth *auth.Registry
+	// Interceptors intercept every tagged stream before it is docked, They are called in order.
+	Interceptors []StreamInterceptor
+	// ACL authorizes clients to publish or observe tags according to their metadata,
+	// Every client can publish and observe any tag if it is nil.
+	ACL *acl.ACL
+	// Tenant isolates the tags of different tenants, Tenants are not isolated if it is nil.
+	Tenant *TenantOption
+	// Reauth reauthenticates clients periodically, Clients are authenticated only once if it is nil.
+	Reauth *ReauthOption
+	// Handshake limits the authentication handshake of clients, There is no limit if it is nil.
+	Handshake *HandshakeOption
+	// DuplicateName decides what happens when a client claims the name of a connected client,
+	// Clients can have the same name by default.
+	DuplicateName DuplicateNamePolicy
+	// MetadataLimits limits the metadata of streams and the metadata returned by authentication,
+	// It is `metadata.DefaultLimits()` if nil, the zero Limits means no limit.
+	MetadataLimits *metadata.Limits
+}
+
+func initServerOption(o *ServerOption) *ServerOption {
+	if o == nil {
+		o = &ServerOption{}
+	}
+	if o.Auth == nil {
+		o.Auth = auth.NewRegistry()
+	}
+	if o.MetadataLimits == nil {
+		limits := metadata.DefaultLimits()
+		o.MetadataLimits = &limits
+	}
+
+	return o
 }
 
 // NewServer creates a new server.
 // The server accepts streams from client and docks them to another client.
-func NewServer(ctx context.Context, Listener, frw *FrameReadWriter, logger *slog.Logger) *Server {
+func NewServer(ctx context.Context, frw *FrameReadWriter, logger *slog.Logger, option *ServerOption) *Server {
 	ctx, ctxCancel := context.WithCancel(ctx)
+	option = initServerOption(option)
+	frw = frw.WithMetadataLimits(*option.MetadataLimits)
 
 	broker := &Server{
-		ctx:          ctx,
-		ctxCancel:    ctxCancel,
-		readerChan:   make(chan taggedReader),
-		readEOFChan:  make(chan string),
-		observerChan: make(chan taggedConnection),
-		logger:       logger,
+		ctx:           ctx,
+		ctxCancel:     ctxCancel,
+		frw:           frw,
+		readerChan:    make(chan taggedReader),
+		observerChan:  make(chan taggedConnection),
+		unobserveChan: make(chan string),
+		option:        option,
+		tenants:       newTenants(option.Tenant),
+		handshake:     newHandshake(option.Handshake),
+		names:         newNames(option.DuplicateName),
+		logger:        logger,
 	}
 
 	go broker.run()
@@ -39,9 +99,8 @@ func NewServer(ctx context.Context, Listener, frw *FrameReadWriter, logger *slog
 	return broker
 }
 
-// handleConn continusly accepts uniStreams from conn and retrives the tag from the reader accepted.
-func (s *Server) handleConn(conn UniStreamConnection) {
-
+// handleConn continusly accepts uniStreams from conn and handles them.
+func (s *Server) handleConn(conn ServerConnection, session *tenantSession) {
 	go func() {
 		for {
 			select {
@@ -55,36 +114,119 @@ func (s *Server) handleConn(conn UniStreamConnection) {
 				break
 			}
 
-			id, tag, err := s.drainReaderFunc(r)
-
-			if err != nil {
-				b.logger.Debug("ack peer stream failed", "error", err)
-				continue
-			}
-			b.readerChan <- taggedReader{id: id, r: r, tag: tag}
+			go s.handleStream(conn, session, r)
 		}
 	}()
 }
 
+// handleStream retrives the header from the reader accepted and passes the stream through the interceptors,
+// The stream will be docked if no interceptor rejects it. The client must be allowed to publish
+// both the tag of the header and the tag rewritten by the interceptors.
+// The stream is acknowledged to the client after it is docked completely or it is canceled.
+func (s *Server) handleStream(conn ServerConnection, session *tenantSession, r ReadStream) {
+	header, err := readStreamHeader(s.frw, r)
+	if err != nil {
+		s.logger.Debug("failed to read stream header", "error", err)
+		code := streamErrorCode(err)
+		if c, ok := metadataRejectCode(err); ok {
+			code = c.StreamErrorCode()
+		}
+		r.CancelRead(code)
+		if header != nil {
+			s.ackStream(conn, header.ID, code)
+		}
+		return
+	}
+	// the stream is acknowledged by the ID read, the interceptors may rewrite the header forwarded.
+	id := header.ID
+	cancel := func(code quic.StreamErrorCode) {
+		r.CancelRead(code)
+		s.ackStream(conn, id, code)
+	}
+
+	tag := header.Tag
+	if !s.authorize(conn, acl.Publish, tag) {
+		cancel(RejectCodeForbidden.StreamErrorCode())
+		return
+	}
+
+	stream := &InterceptedStream{
+		Header:   header,
+		Metadata: conn.Metadata(),
+		Reader:   r,
+	}
+	if err := intercept(s.option.Interceptors, stream); err != nil {
+		s.logger.Debug("stream is rejected by interceptor", "stream_id", header.ID, "tag", tag, "error", err)
+		cancel(streamErrorCode(err))
+		return
+	}
+	// the tag rewritten by the interceptors must be allowed too, so the client cannot publish to a tag forbidden to it.
+	if stream.Header.Tag != tag && !s.authorize(conn, acl.Publish, stream.Header.Tag) {
+		cancel(RejectCodeForbidden.StreamErrorCode())
+		return
+	}
+
+	if err := session.useTag(stream.Header.Tag); err != nil {
+		s.rejectLimitExceeded(conn, err)
+		cancel(RejectCodeLimitExceeded.StreamErrorCode())
+		return
+	}
+
+	item := taggedReader{
+		tag:    session.namespace(stream.Header.Tag),
+		header: stream.Header,
+		r:      session.limit(stream.Reader),
+		ack:    func(code quic.StreamErrorCode) { s.ackStream(conn, id, code) },
+	}
+
+	select {
+	case <-s.ctx.Done():
+	case s.readerChan <- item:
+	}
+}
+
 // Observe makes the conn observe the given tag.
 // If an conn observes a tag, it will be notified to open a new stream to dock with
 // the tagged stream when it arrives.
-func (b *Broker) Observe(tag string, conn UniStreamConnection) {
+func (s *Server) Observe(tag string, conn UniStreamConnection) {
 	item := taggedConnection{
 		tag:  tag,
 		conn: conn,
 	}
-	b.logger.Debug("accept an observer", "tag", tag, "conn_id", conn.ID())
-	b.observerChan <- item
+	s.logger.Debug("accept an observer", "tag", tag, "conn_id", conn.ID())
+	select {
+	case <-s.ctx.Done():
+	case s.observerChan <- item:
+	}
 }
 
-// Close closes the broker.
-func (b *Broker) Close() error {
-	b.ctxCancel()
+// unobserve stops the connection with the ID from observing any tag.
+func (s *Server) unobserve(connID string) {
+	select {
+	case <-s.ctx.Done():
+	case s.unobserveChan <- connID:
+	}
+}
+
+// Close closes the server, the connected clients are rejected with `RejectCodeGoingAway`.
+func (s *Server) Close() error {
+	s.goAway()
+	s.ctxCancel()
 	return nil
 }
 
-func (b *Broker) run() {
+// goAway rejects all the authenticated clients with `RejectCodeGoingAway` once,
+// so they can reconnect to another server rather than treating it as an error.
+func (s *Server) goAway() {
+	s.goingAway.Do(func() {
+		s.controllers.Range(func(_, v any) bool {
+			v.(*ServerController).rejectWithCloseConn(RejectCodeGoingAway, "server is going away")
+			return true
+		})
+	})
+}
+
+func (s *Server) run() {
 	var (
 		// observers is a collection of connections.
 		// The keys in observers are tags that are used to identify the observers.
@@ -94,27 +236,27 @@ func (b *Broker) run() {
 
 		// readers stores readers.
 		// The key is reader tag,
-		// The value is a map where the keys are the id and the value is the reader.
+		// The value is a map where the keys are the stream id and the value is the reader.
 		// Using a map means that each tag only has one corresponding reader and
 		// new stream cannot cover the old stream in same tag.
-		readers = make(map[string]map[string]io.Reader)
+		readers = make(map[string]map[string]taggedReader)
 	)
 	for {
 		select {
-		case <-b.ctx.Done():
-			b.logger.Debug("broker is closed")
+		case <-s.ctx.Done():
+			s.logger.Debug("server is closed")
 			return
-		case o := <-b.observerChan:
+		case o := <-s.observerChan:
 			// if the writer opener is already registered, observe the writer directly.
 			rm, ok := readers[o.tag]
 			if ok {
 				for rid, r := range rm {
 					w, err := o.conn.OpenUniStream()
 					if err != nil {
-						b.logger.Debug("failed to accept a uniStream", "error", err)
+						s.logger.Debug("failed to accept a uniStream", "error", err)
 						continue
 					}
-					go b.copyWithLog(o.tag, w, r, b.logger)
+					go s.dock(w, r)
 					// delete the reader that has been observed.
 					delete(rm, rid)
 					if len(rm) == 0 {
@@ -132,18 +274,19 @@ func (b *Broker) run() {
 			} else {
 				m[o.conn.ID()] = o.conn
 			}
-		case r := <-b.readerChan:
+		case r := <-s.readerChan:
 			// if there donot have any observers,
 			// store the reader for waiting comming observer to observe it.
-			vv, ok := observers[r.tag]
+			tag := r.tag
+			vv, ok := observers[tag]
 			if !ok {
-				rm, ok := readers[r.tag]
+				rm, ok := readers[tag]
 				if ok {
-					rm[r.id] = r.r
+					rm[r.header.ID] = r
 				} else {
 					// if there donot has an old writer, store it.
-					readers[r.tag] = map[string]io.Reader{
-						r.id: r.r,
+					readers[tag] = map[string]taggedReader{
+						r.header.ID: r,
 					}
 				}
 				continue
@@ -153,68 +296,107 @@ func (b *Broker) run() {
 			for _, opener := range vv {
 				w, err := opener.OpenUniStream()
 				if err != nil {
-					b.logger.Debug("failed to accept a uniStream", "error", err)
+					s.logger.Debug("failed to accept a uniStream", "error", err)
 					delete(vv, opener.ID())
 					break
 				}
 				// one observer can only observe once.
 				delete(vv, opener.ID())
 				if len(vv) == 0 {
-					delete(observers, r.tag)
+					delete(observers, tag)
 				}
 
-				go b.copyWithLog(r.tag, w, r.r, b.logger)
+				go s.dock(w, r)
+			}
+		case id := <-s.unobserveChan:
+			for tag, m := range observers {
+				delete(m, id)
+				if len(m) == 0 {
+					delete(observers, tag)
+				}
 			}
-		case tag := <-b.readEOFChan:
-			delete(readers, tag)
 		}
 	}
 }
 
-func (b *Broker) copyWithLog(tag string, dst io.Writer, src io.Reader, logger *slog.Logger) {
-	_, err := io.Copy(dst, src)
-	if err != nil {
-		if err == io.EOF {
-			b.readEOFChan <- tag
-			logger.Debug("writing to all observers has been completed.")
-		} else {
-			logger.Debug("failed to write a uniStream", "error", err)
-		}
+// dock forwards the header of src to dst, and then copies the stream data from src to dst.
+// The cancellation of one side of the docked streams is propagated to the other side with the same error code,
+// and the writer of src is acknowledged whether the stream is delivered.
+func (s *Server) dock(dst WriteStream, src taggedReader) {
+	if err := writeStreamHeader(s.frw, dst, src.header); err != nil {
+		s.logger.Debug("failed to forward stream header", "stream_id", src.header.ID, "error", err)
+		code := streamErrorCode(err)
+		dst.CancelWrite(code)
+		src.r.CancelRead(code)
+		src.ack(code)
+		return
+	}
+
+	if err := copyStream(dst, src.r); err != nil {
+		s.logger.Debug("failed to write a uniStream", "stream_id", src.header.ID, "error", err)
+		src.ack(streamErrorCode(err))
+		return
+	}
+	s.logger.Debug("writing to observer has been completed", "stream_id", src.header.ID)
+	src.ack(RejectCodeClosed.StreamErrorCode())
+}
+
+// ackStream tells the client whether the stream with the ID is delivered, code is RejectCodeClosed if it is.
+func (s *Server) ackStream(conn ServerConnection, id string, code quic.StreamErrorCode) {
+	if err := conn.AckStream(id, RejectCode(code)); err != nil {
+		s.logger.Debug("failed to acknowledge the stream", "stream_id", id, "error", err)
 	}
 }
 
 // WriterOpener opens WriteCloser in specified tag.
 type WriterOpener interface {
+	// Context returns the context of the observed stream, `metadata.FromContext` returns the metadata of it.
+	// It is canceled when the handling of the stream ends or the client is closed.
 	Context() context.Context
-	// Open opens WriteCloser.
-	Open(tag string) (io.WriteCloser, error)
+	// Open opens WriteCloser, the metadata is carried in the header of the stream.
+	Open(tag string, md metadata.MD) (WriteStream, error)
 }
 
 // Observer is responsible for handling tagged streams.
 type Observer interface {
 	// Handle is the function responsible for handling tagged streams and writing to a new peer stream.
-	// Reading data from Reader and Using WriterOpener to open writer.
-	Handle(WriterOpener, io.Reader)
+	// The header tells where the stream comes from, Reading data from Reader and Using WriterOpener to open writer.
+	Handle(WriterOpener, *StreamHeader, ReadStream)
 }
 
 // ObserveHandleFunc handles tagged streams.
-type ObserveHandleFunc func(opener WriterOpener, r io.Reader)
+type ObserveHandleFunc func(opener WriterOpener, header *StreamHeader, r ReadStream)
 
 // Handle calls ObserveHandleFunc itself.
-func (f ObserveHandleFunc) Handle(opener WriterOpener, r io.Reader) { f(opener, r) }
+func (f ObserveHandleFunc) Handle(opener WriterOpener, header *StreamHeader, r ReadStream) {
+	f(opener, header, r)
+}
 
 // UniStreamConnection opens and accepts uniStream.
 type UniStreamConnection interface {
 	// ID returns the ID of the connection.
 	ID() string
 	// OpenUniStream opens uniStream.
-	OpenUniStream() (io.WriteCloser, error)
+	OpenUniStream() (WriteStream, error)
 	// AcceptUniStream accepts uniStream.
-	AcceptUniStream(context.Context) (io.ReadCloser, error)
+	AcceptUniStream(context.Context) (ReadStream, error)
 	// Close closes the connection.
 	io.Closer
 }
 
+// ServerConnection is the server-side UniStreamConnection of an authenticated client.
+type ServerConnection interface {
+	// basic connection.
+	UniStreamConnection
+	// Metadata returns the metadata of the client, It is returned by the authentication.
+	Metadata() metadata.MD
+	// Reject writes a RejectedFrame to the client to reject a reqeust.
+	Reject(code RejectCode, message string) error
+	// AckStream writes a StreamAckFrame to the client, code is RejectCodeClosed if the stream is delivered,
+	// otherwise it is the code that the stream is canceled with.
+	AckStream(id string, code RejectCode) error
+}
+
 // UniStreamPeerConnection opens and accepts uniStreams,
 // Adding a new method for requesting observe a tag. just work for peer side.
 type UniStreamPeerConnection interface {
@@ -225,9 +407,12 @@ type UniStreamPeerConnection interface {
 }
 
 type taggedReader struct {
-	id  string
-	tag string
-	r   io.ReadCloser
+	// tag is the tag used to dock the stream, it is namespaced by tenant.
+	tag    string
+	header *StreamHeader
+	r      ReadStream
+	// ack acknowledges the stream to its writer after it is docked.
+	ack func(code quic.StreamErrorCode)
 }
 
 type taggedConnection struct {
@@ -2,11 +2,15 @@ package core
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"io"
+	"net"
+	"sync"
+	"time"
 
 	"github.com/quic-go/quic-go"
+	"github.com/woorui/ydesign/core/auth"
 	"github.com/woorui/ydesign/core/frame"
 	"github.com/woorui/ydesign/core/metadata"
 	"golang.org/x/exp/slog"
@@ -21,24 +25,39 @@ type ServerController struct {
 	stream0 quic.Stream
 	frw     *FrameReadWriter
 
+	// name is the stable name presented by client in authentication, it is empty if the client has no name.
+	name string
+	// md is the metadata returned by authentication.
+	md metadata.MD
+	// observed is the tags observed by client, they are transferred to the new session if it is taken over.
+	observed map[string]struct{}
+	// mu guards md, observed and the writing of stream0.
+	mu sync.Mutex
+
 	observeChan chan string
+	// authChan and challengeChan receive the frames answered by client during reauthentication.
+	authChan      chan *frame.AuthenticationFrame
+	challengeChan chan *frame.AuthenticationChallengeFrame
 
 	logger *slog.Logger
 }
 
-const serverCloseCode = quic.ApplicationErrorCode(0xDF)
-
 // NewServerControlStream returns ServerControlStream from the first stream of this Connection.
 func NewServerController(
 	ctx context.Context,
 	conn quic.Connection, stream0 quic.Stream,
 	frw *FrameReadWriter, logger *slog.Logger, idGenerator func() string) *ServerController {
 	controller := &ServerController{
-		id:      idGenerator(),
-		ctx:     ctx,
-		stream0: stream0,
-		frw:     frw,
-		logger:  logger,
+		id:            idGenerator(),
+		ctx:           ctx,
+		conn:          conn,
+		stream0:       stream0,
+		frw:           frw,
+		observed:      make(map[string]struct{}),
+		observeChan:   make(chan string),
+		authChan:      make(chan *frame.AuthenticationFrame, 1),
+		challengeChan: make(chan *frame.AuthenticationChallengeFrame, 1),
+		logger:        logger,
 	}
 
 	return controller
@@ -56,6 +75,10 @@ func (ss *ServerController) readFrameLoop() {
 		switch ff := f.(type) {
 		case *frame.ObserveFrame:
 			ss.observeChan <- ff.Tag
+		case *frame.AuthenticationFrame:
+			deliver(ss, ss.authChan, ff)
+		case *frame.AuthenticationChallengeFrame:
+			deliver(ss, ss.challengeChan, ff)
 		default:
 			ss.logger.Debug("control stream read unexpected frame", "frame_type", f.Type().String())
 		}
@@ -66,54 +89,146 @@ func (ss *ServerController) ID() string {
 	return ss.id
 }
 
-func (ss *ServerController) AcceptUniStream(ctx context.Context) (io.Reader, error) {
+// Name returns the stable name presented by client in authentication.
+func (ss *ServerController) Name() string {
+	return ss.name
+}
+
+// addObserved records that the client observes the tag.
+func (ss *ServerController) addObserved(tag string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.observed[tag] = struct{}{}
+}
+
+// observedTags returns the tags observed by client.
+func (ss *ServerController) observedTags() []string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	tags := make([]string, 0, len(ss.observed))
+	for tag := range ss.observed {
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// Metadata returns the metadata returned by authentication.
+func (ss *ServerController) Metadata() metadata.MD {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	return ss.md
+}
+
+func (ss *ServerController) AcceptUniStream(ctx context.Context) (ReadStream, error) {
 	return ss.conn.AcceptUniStream(ctx)
 }
 
-func (ss *ServerController) OpenUniStream() (io.WriteCloser, error) {
+func (ss *ServerController) OpenUniStream() (WriteStream, error) {
 	return ss.conn.OpenUniStream()
 }
 
+// Close closes the server-side control stream.
+func (ss *ServerController) Close() error {
+	return ss.CloseWithError("")
+}
+
 // CloseWithError closes the server-side control stream.
 func (ss *ServerController) CloseWithError(errString string) error {
-	return ss.conn.CloseWithError(serverCloseCode, errString)
+	return ss.conn.CloseWithError(RejectCodeClosed.ApplicationErrorCode(), errString)
+}
+
+// Reject writes a RejectedFrame to the client to reject a reqeust without closing the connection.
+func (ss *ServerController) Reject(code RejectCode, message string) error {
+	rejected := &frame.RejectedFrame{
+		Code:    uint64(code),
+		Message: message,
+	}
+
+	return ss.writeFrame(rejected)
 }
 
-// VerifyAuthenticationFunc is used by server control stream to verify authentication.
-type VerifyAuthenticationFunc func(*frame.AuthenticationFrame) (metadata.MD, bool, error)
+// AckStream writes a StreamAckFrame to the client, code is RejectCodeClosed if the stream is delivered.
+func (ss *ServerController) AckStream(id string, code RejectCode) error {
+	return ss.writeFrame(&frame.StreamAckFrame{ID: id, Code: uint64(code)})
+}
 
-// VerifyAuthentication verify the Authentication from client side.
+// rejectObserve writes a RejectedFrame to the client to reject its request to observe the tag.
+func (ss *ServerController) rejectObserve(tag string, code RejectCode, message string) error {
+	rejected := &frame.RejectedFrame{
+		Code:    uint64(code),
+		Message: message,
+		Tag:     tag,
+	}
+
+	return ss.writeFrame(rejected)
+}
+
+// writeFrame writes the frame to stream0, it is safe for concurrent use.
+func (ss *ServerController) writeFrame(f frame.Frame) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	return ss.frw.WriteFrame(ss.stream0, f)
+}
+
+// VerifyAuthenticationFunc is used by server control stream to verify authentication,
+// The tls.ConnectionState is the TLS state of the connection, it allows authenticating clients with their certificates.
+// The auth.ChallengeFunc challenges the client, it allows multi-step authentication.
+// Returning an `*auth.DeniedError` rejects the client with the reason, and returning a `*RejectedError` rejects
+// the client with its code.
+type VerifyAuthenticationFunc func(*frame.AuthenticationFrame, tls.ConnectionState, auth.ChallengeFunc) (metadata.MD, bool, error)
+
+// VerifyAuthentication verify the Authentication from client side,
+// The client is rejected and the connection is closed if it returns an error.
 func (ss *ServerController) VerifyAuthentication(verifyFunc VerifyAuthenticationFunc) (metadata.MD, error) {
 	first, err := ss.frw.Readframe(ss.stream0)
 	if err != nil {
-		return nil, err
+		return nil, ss.authFailed(RejectCodeUnexpectedFrame, err)
 	}
 
 	received, ok := first.(*frame.AuthenticationFrame)
 	if !ok {
-		errString := fmt.Sprintf("authentication failed: read unexcepted frame, frame read: %s", received.Type().String())
-		ss.rejectWithCloseConn(224, errString)
+		errString := fmt.Sprintf("authentication failed: read unexcepted frame, frame read: %s", first.Type().String())
+		ss.rejectWithCloseConn(RejectCodeUnexpectedFrame, errString)
 		return nil, errors.New(errString)
 	}
 
-	md, ok, err := verifyFunc(received)
-	if err != nil {
+	md, ok, err := verifyFunc(received, ss.conn.ConnectionState().TLS.ConnectionState, ss.challenge)
+	if rejected := new(RejectedError); errors.As(err, &rejected) {
+		ss.rejectWithCloseConn(rejected.Code, rejected.Message)
 		return md, err
 	}
+	denied := new(auth.DeniedError)
+	if err != nil && !errors.As(err, &denied) {
+		return md, ss.authFailed(RejectCodeAuthFailed, err)
+	}
 
 	// authentication failed.
 	if !ok {
 		errString := fmt.Sprintf("authentication failed: client credential name is %s", received.AuthName)
-		ss.rejectWithCloseConn(223, errString)
+		if denied.Reason != "" {
+			errString = fmt.Sprintf("authentication failed: %s", denied.Reason)
+		}
+		ss.rejectWithCloseConn(RejectCodeAuthFailed, errString)
 		return md, errors.New(errString)
 	}
 
-	// authentication successful.
+	// authentication successful, the control stream lives as long as the connection.
+	if err := ss.stream0.SetDeadline(time.Time{}); err != nil {
+		return md, ss.authFailed(RejectCodeAuthFailed, err)
+	}
+	ss.mu.Lock()
+	ss.name = received.ClientName
+	ss.md = md
+	ss.mu.Unlock()
 	ack := &frame.AuthenticationAckFrame{
 		ID: ss.id,
 	}
-	if err := ss.frw.WriteFrame(ss.stream0, ack); err != nil {
-		return md, err
+	if err := ss.writeFrame(ack); err != nil {
+		return md, ss.authFailed(RejectCodeAuthFailed, err)
 	}
 
 	// create a goroutinue to continuous read frame after verify authentication successful.
@@ -122,18 +237,45 @@ func (ss *ServerController) VerifyAuthentication(verifyFunc VerifyAuthentication
 	return md, nil
 }
 
-func (ss *ServerController) rejectWithCloseConn(code int64, msg string) {
-	rejected := &frame.RejectedFrame{
-		Code:    224,
-		Message: msg,
+// challenge sends the challenge to the client and waits for the response during authentication.
+func (ss *ServerController) challenge(challenge string) (string, error) {
+	if err := ss.frw.WriteFrame(ss.stream0, &frame.AuthenticationChallengeFrame{Payload: challenge}); err != nil {
+		return "", err
 	}
 
-	err := ss.frw.WriteFrame(ss.stream0, rejected)
+	received, err := ss.frw.Readframe(ss.stream0)
 	if err != nil {
+		return "", err
+	}
+
+	f, ok := received.(*frame.AuthenticationChallengeFrame)
+	if !ok {
+		return "", fmt.Errorf("authentication failed: read unexcepted frame during challenge, frame read: %s", received.Type().String())
+	}
+
+	return f.Payload, nil
+}
+
+// authFailed rejects the client with the code because of err during authentication and closes the connection,
+// The client is rejected with `RejectCodeHandshakeTimeout` if err is a timeout.
+func (ss *ServerController) authFailed(code RejectCode, err error) error {
+	if nerr := net.Error(nil); errors.As(err, &nerr) && nerr.Timeout() {
+		// clear the deadline so that the rejection can be written.
+		_ = ss.stream0.SetDeadline(time.Time{})
+		code, err = RejectCodeHandshakeTimeout, errors.New("handshake timeout")
+	}
+	errString := fmt.Sprintf("authentication failed: %s", err)
+	ss.rejectWithCloseConn(code, errString)
+	return errors.New(errString)
+}
+
+// rejectWithCloseConn rejects the client with the code and closes the connection with the same code.
+func (ss *ServerController) rejectWithCloseConn(code RejectCode, msg string) {
+	if err := ss.Reject(code, msg); err != nil {
 		ss.logger.Debug("server write rejected frame failed", "err", err)
 	}
 
-	if err = ss.CloseWithError(msg); err != nil {
+	if err := ss.conn.CloseWithError(code.ApplicationErrorCode(), msg); err != nil {
 		ss.logger.Debug("server colse rejected conn connection failed", "err", err)
 	}
 }
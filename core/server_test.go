@@ -0,0 +1,179 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/auth"
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+func TestServerDefaultAuth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the authentications registered to the package-level registry are not used implicitly.
+	option := initServerOption(nil)
+	assert.NotSame(t, auth.Default(), option.Auth)
+
+	auth.Register(auth.NewTokenAuth(auth.Token{Token: "default"}))
+	defer auth.Default().Unregister("token")
+
+	addr := listenServer(t, ctx, option)
+	_, err := OpenClient(ctx, addr, WithTLSConfig(insecureTLSConfig()), WithCredential("token:default"))
+	assert.ErrorIs(t, err, RejectCodeAuthFailed)
+}
+
+func TestServerGoingAway(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	serverCtx, closeServer := context.WithCancel(ctx)
+	addr := listenServer(t, serverCtx, nil)
+
+	client := openTestClient(t, ctx, addr)
+	_, errCh := observe(client, "sensors")
+	time.Sleep(100 * time.Millisecond)
+
+	closeServer()
+	select {
+	case err := <-errCh:
+		assert.ErrorIs(t, err, RejectCodeGoingAway)
+		assert.True(t, err.(*RejectedError).Temporary())
+	case <-ctx.Done():
+		t.Fatal("the client is not rejected")
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server, addr := startServer(t, ctx, "127.0.0.1:0", nil)
+
+	client := openTestClient(t, ctx, addr)
+	_, errCh := observe(client, "sensors")
+	time.Sleep(100 * time.Millisecond)
+
+	assert.NoError(t, server.Close())
+	select {
+	case err := <-errCh:
+		assert.ErrorIs(t, err, RejectCodeGoingAway)
+	case <-ctx.Done():
+		t.Fatal("the client is not rejected")
+	}
+
+	// observing on the closed server does not block.
+	done := make(chan struct{})
+	go func() {
+		server.Observe("sensors", newFakeController("a"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("observing on the closed server blocks")
+	}
+}
+
+// writeRawHeader opens a stream with the client and writes f as the header, It returns the error
+// that the server cancels the stream with.
+func writeRawHeader(t *testing.T, client *Client, f *frame.OpenStreamFrame) error {
+	w, err := client.conn.OpenUniStream()
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	frw := NewFrameReadWriter(frame.NewPacketCodec(0), frame.NewCodec())
+	var serr *quic.StreamError
+	if err := frw.WriteFrame(w, f); errors.As(err, &serr) {
+		// the server cancels the stream before the header is written.
+		return asRejectedError(err)
+	} else if err != nil {
+		t.Fatalf("failed to write header: %v", err)
+	}
+
+	assert.Eventually(t, func() bool {
+		_, err = w.Write([]byte("data"))
+		return errors.As(err, &serr)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return asRejectedError(err)
+}
+
+func TestServerUnsupportedMetadataVersion(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := listenServer(t, ctx, nil)
+	client := openTestClient(t, ctx, addr)
+
+	// the metadata is in the binary encoding of an unknown version.
+	err := writeRawHeader(t, client, &frame.OpenStreamFrame{ID: "1", Tag: "sensors", Metadata: []byte{0x00, 0x7f, 0x00}})
+	assert.ErrorIs(t, err, RejectCodeUnsupportedVersion)
+}
+
+func TestServerMetadataLimits(t *testing.T) {
+	limits := metadata.Limits{MaxSize: 256, MaxKeys: 4, MaxValueLength: 16}
+	// the values are within the limit of length, but they are too large together.
+	values := make([]string, 20)
+	for i := range values {
+		values[i] = strings.Repeat("x", 16)
+	}
+
+	tests := []struct {
+		name string
+		md   metadata.MD
+		code RejectCode
+	}{
+		{name: "too large", md: metadata.MD{"a": values}, code: RejectCodeMetadataTooLarge},
+		{name: "too many keys", md: metadata.MD{"a": {"1"}, "b": {"2"}, "c": {"3"}, "d": {"4"}, "e": {"5"}}, code: RejectCodeMetadataTooManyKeys},
+		{name: "too long", md: metadata.MD{"a": {strings.Repeat("x", 17)}}, code: RejectCodeMetadataTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			registry := auth.NewRegistry()
+			registry.AllowAnonymous(metadata.MD{})
+			registry.Register(auth.NewTokenAuth(auth.Token{Token: "huge", Metadata: tt.md}))
+			addr := listenServer(t, ctx, &ServerOption{Auth: registry, MetadataLimits: &limits})
+
+			// the metadata of stream header exceeds the limits.
+			data, err := tt.md.Encode()
+			assert.NoError(t, err)
+			err = writeRawHeader(t, openTestClient(t, ctx, addr), &frame.OpenStreamFrame{ID: "1", Tag: "sensors", Metadata: data})
+			assert.ErrorIs(t, err, tt.code)
+
+			// the metadata returned by authentication exceeds the limits.
+			_, err = OpenClient(ctx, addr, WithTLSConfig(insecureTLSConfig()), WithCredential("token:huge"))
+			assert.ErrorIs(t, err, tt.code)
+		})
+	}
+}
+
+func TestServerDefaultMetadataLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	option := initServerOption(nil)
+	assert.Equal(t, metadata.DefaultLimits(), *option.MetadataLimits)
+
+	addr := listenServer(t, ctx, nil)
+	data, err := metadata.MD{"a": {strings.Repeat("x", metadata.DefaultLimits().MaxValueLength+1)}}.Encode()
+	assert.NoError(t, err)
+	err = writeRawHeader(t, openTestClient(t, ctx, addr), &frame.OpenStreamFrame{ID: "1", Tag: "sensors", Metadata: data})
+	assert.ErrorIs(t, err, RejectCodeMetadataTooLong)
+
+	// the header far larger than the limits is refused before its metadata is read and decoded.
+	data = make([]byte, metadata.DefaultLimits().MaxSize+maxFrameOverhead)
+	data[1] = 0x7f
+	err = writeRawHeader(t, openTestClient(t, ctx, addr), &frame.OpenStreamFrame{ID: "1", Tag: "sensors", Metadata: data})
+	assert.ErrorIs(t, err, RejectCodeMetadataTooLarge)
+}
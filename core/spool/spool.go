@@ -0,0 +1,537 @@
+// Package spool provides an on-disk queue of records made of append-only segment files,
+// It stores the data written while the client is offline and replays it in order after reconnecting.
+package spool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSegmentSize is the default size that a segment file is rolled at.
+	DefaultSegmentSize = 4 << 20
+
+	segmentExt = ".seg"
+	cursorFile = "cursor"
+	// recordHeaderSize is the size of the record header: the time, the length and the CRC32 of the data.
+	recordHeaderSize = 8 + 4 + 4
+)
+
+var (
+	// ErrFull is returned by Append when the spool reaches its MaxSize.
+	ErrFull = errors.New("spool: spool is full")
+	// ErrClosed is returned when using a closed spool.
+	ErrClosed = errors.New("spool: spool is closed")
+	// ErrNoRecord is returned by Commit when there is no record returned by Peek or Next.
+	ErrNoRecord = errors.New("spool: no record is read")
+)
+
+// Option is the option of Spool, The zero fields are the defaults.
+type Option struct {
+	// SegmentSize is the size that a segment file is rolled at, It is DefaultSegmentSize if it is 0.
+	SegmentSize int64
+	// MaxSize is the size limit of the records not committed, Append fails with ErrFull if it is exceeded.
+	// 0 means no limit. The segment files may exceed it by the records committed in the segments not removed yet.
+	MaxSize int64
+	// MaxAge is the age limit of the records, The older records are dropped rather than replayed.
+	// 0 means no limit.
+	MaxAge time.Duration
+	// Sync syncs the segment file after every Append, so that the records survive the power loss.
+	Sync bool
+}
+
+// Record is a record in the spool.
+type Record struct {
+	// Time is the time when the record is appended.
+	Time time.Time
+	// Data is the data of the record.
+	Data []byte
+}
+
+// Spool is an on-disk queue of records, It is safe for concurrent use by one reader and many writers.
+//
+// The records are appended to the last segment file, and a new segment is started when it reaches the SegmentSize
+// or when the spool is drained. The position of the records committed is kept in the cursor file, and a segment file
+// is removed after its records are committed, so the records are delivered at least once across restarts.
+type Spool struct {
+	dir    string
+	option Option
+
+	mu sync.Mutex
+	// segments is the sequence numbers of the segment files in order.
+	segments []uint64
+	// tail is the last segment which is appended to.
+	tail     *os.File
+	tailSize int64
+	// offset is the position of the first record not committed in the first segment.
+	offset int64
+	// reader is the segments[readSeg] which is read from, readOffset is the position of the next record in it.
+	reader     *os.File
+	readSeg    int
+	readOffset int64
+	// read is the number of the records read but not committed, readSize is the size of them.
+	read     int
+	readSize int64
+	// size is the size of the records not committed, count is the number of them.
+	size   int64
+	count  int
+	closed bool
+}
+
+// Open opens the spool in dir, dir is created if it does not exist.
+// The torn record at the end of the last segment, which is left by a crash, is truncated.
+func Open(dir string, option Option) (*Spool, error) {
+	if option.SegmentSize <= 0 {
+		option.SegmentSize = DefaultSegmentSize
+	}
+	if option.MaxSize < 0 || option.MaxAge < 0 {
+		return nil, errors.New("spool: limits cannot be negative")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir, option: option}
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load loads the segments and the cursor from dir.
+func (s *Spool) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 16, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, seq)
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	headSeq, offset, err := s.readCursor()
+	if err != nil {
+		return err
+	}
+	// remove the segments which are read.
+	for len(s.segments) > 0 && s.segments[0] < headSeq {
+		if err := os.Remove(s.segmentPath(s.segments[0])); err != nil {
+			return err
+		}
+		s.segments = s.segments[1:]
+	}
+	if len(s.segments) == 0 || s.segments[0] != headSeq {
+		offset = 0
+	}
+
+	for i, seq := range s.segments {
+		start := int64(0)
+		if i == 0 {
+			start = offset
+		}
+		size, count, valid, err := scanSegment(s.segmentPath(seq), start)
+		if err != nil {
+			return err
+		}
+		if valid < size {
+			if i != len(s.segments)-1 {
+				return fmt.Errorf("spool: segment %s is corrupted at %d", s.segmentPath(seq), valid)
+			}
+			if err := os.Truncate(s.segmentPath(seq), valid); err != nil {
+				return err
+			}
+			size = valid
+		}
+		s.size += size - start
+		s.count += count
+	}
+
+	if len(s.segments) == 0 {
+		s.segments = append(s.segments, headSeq)
+	}
+	if err := s.openTail(); err != nil {
+		return err
+	}
+	s.offset = offset
+
+	return s.openReader(0, offset)
+}
+
+// scanSegment scans the records of the segment from start, It returns the size of the file,
+// the number of the records after start and the size of the valid records.
+func scanSegment(path string, start int64) (size int64, count int, valid int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	size = info.Size()
+	if start > size {
+		return 0, 0, 0, fmt.Errorf("spool: cursor %d is beyond the segment %s", start, path)
+	}
+
+	valid = 0
+	for valid < size {
+		n, _, err := readRecord(f, valid, size)
+		if err != nil {
+			// a torn record, it is truncated by the caller.
+			break
+		}
+		if valid >= start {
+			count++
+		}
+		valid += n
+	}
+
+	return size, count, valid, nil
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016x%s", seq, segmentExt))
+}
+
+// openTail opens the last segment for appending.
+func (s *Spool) openTail() error {
+	seq := s.segments[len(s.segments)-1]
+	tail, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := tail.Stat()
+	if err != nil {
+		tail.Close()
+		return err
+	}
+	s.tail = tail
+	s.tailSize = info.Size()
+
+	return nil
+}
+
+// openReader opens segments[seg] for reading from offset.
+func (s *Spool) openReader(seg int, offset int64) error {
+	reader, err := os.Open(s.segmentPath(s.segments[seg]))
+	if err != nil {
+		return err
+	}
+	if s.reader != nil {
+		s.reader.Close()
+	}
+	s.reader, s.readSeg, s.readOffset = reader, seg, offset
+
+	return nil
+}
+
+// readCursor returns the sequence number of the head segment and the offset in it.
+func (s *Spool) readCursor() (uint64, int64, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, cursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(data) != 16 {
+		return 0, 0, errors.New("spool: invalid cursor file")
+	}
+	return binary.BigEndian.Uint64(data), int64(binary.BigEndian.Uint64(data[8:])), nil
+}
+
+// writeCursor persists the position of the reader.
+func (s *Spool) writeCursor() error {
+	var data [16]byte
+	binary.BigEndian.PutUint64(data[:], s.segments[0])
+	binary.BigEndian.PutUint64(data[8:], uint64(s.offset))
+
+	// write and rename, so that the cursor is never torn.
+	path := filepath.Join(s.dir, cursorFile)
+	if err := os.WriteFile(path+".tmp", data[:], 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Append appends a record with the data.
+func (s *Spool) Append(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	n := int64(recordHeaderSize + len(data))
+	if s.option.MaxSize > 0 && s.size+n > s.option.MaxSize && s.option.MaxAge > 0 && s.read == 0 {
+		// make room by dropping the expired records.
+		if _, _, err := s.next(); err != nil {
+			return err
+		}
+		if err := s.rewind(); err != nil {
+			return err
+		}
+	}
+	if s.option.MaxSize > 0 && s.size+n > s.option.MaxSize {
+		return ErrFull
+	}
+	// start a new segment if the spool is drained, so that the segment read is removed rather than kept growing.
+	if s.tailSize > 0 && (s.count == 0 || s.tailSize+n > s.option.SegmentSize) {
+		if err := s.roll(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, recordHeaderSize, n)
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[12:], crc32.ChecksumIEEE(data))
+	buf = append(buf, data...)
+
+	if _, err := s.tail.Write(buf); err != nil {
+		return err
+	}
+	if s.option.Sync {
+		if err := s.tail.Sync(); err != nil {
+			return err
+		}
+	}
+	s.tailSize += n
+	s.size += n
+	s.count++
+
+	return nil
+}
+
+// roll starts a new segment.
+func (s *Spool) roll() error {
+	if err := s.tail.Close(); err != nil {
+		return err
+	}
+	s.segments = append(s.segments, s.segments[len(s.segments)-1]+1)
+	return s.openTail()
+}
+
+// Peek returns the first record not committed, It returns false if there is no record.
+// The record is returned again until it is committed, The records older than the MaxAge are dropped.
+// It rewinds the records returned by Next.
+func (s *Spool) Peek() (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return Record{}, false, ErrClosed
+	}
+	if err := s.rewind(); err != nil {
+		return Record{}, false, err
+	}
+	return s.next()
+}
+
+// Next returns the record after the ones returned since the last Commit or Rewind, It returns false
+// if they are all returned. It reads the records ahead of the commit, so that many records are sent
+// before they are committed together. The records older than the MaxAge are dropped.
+func (s *Spool) Next() (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return Record{}, false, ErrClosed
+	}
+	return s.next()
+}
+
+// next reads the next record not read, the expired records are dropped.
+func (s *Spool) next() (Record, bool, error) {
+	for s.count > s.read {
+		n, rec, err := readRecord(s.reader, s.readOffset, math.MaxInt64)
+		if errors.Is(err, io.EOF) {
+			// the segment is read, move to the next one.
+			if s.readSeg == len(s.segments)-1 {
+				return Record{}, false, fmt.Errorf("spool: %d records are missing", s.count-s.read)
+			}
+			if err := s.openReader(s.readSeg+1, 0); err != nil {
+				return Record{}, false, err
+			}
+			if s.read == 0 {
+				// remove the segment read.
+				if err := s.commit(); err != nil {
+					return Record{}, false, err
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return Record{}, false, err
+		}
+		s.readOffset += n
+		s.read++
+		s.readSize += n
+		if s.option.MaxAge > 0 && time.Since(rec.Time) > s.option.MaxAge {
+			// the expired record is committed with the records read before it.
+			if s.read == 1 {
+				if err := s.commit(); err != nil {
+					return Record{}, false, err
+				}
+			}
+			continue
+		}
+		return rec, true, nil
+	}
+
+	return Record{}, false, nil
+}
+
+// Commit removes the records returned by Peek or Next since the last Commit or Rewind.
+func (s *Spool) Commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	if s.read == 0 {
+		return ErrNoRecord
+	}
+	return s.commit()
+}
+
+// commit moves the cursor to the position of the reader and removes the segments read.
+func (s *Spool) commit() error {
+	removed := s.segments[:s.readSeg]
+	s.segments = s.segments[s.readSeg:]
+	s.readSeg = 0
+	s.offset = s.readOffset
+	s.count -= s.read
+	s.size -= s.readSize
+	s.read, s.readSize = 0, 0
+
+	// persist the cursor before removing the segments, so that they are never read again.
+	if err := s.writeCursor(); err != nil {
+		return err
+	}
+	for _, seq := range removed {
+		if err := os.Remove(s.segmentPath(seq)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rewind makes the records returned by Peek or Next since the last Commit be returned again,
+// It is called when they are not delivered.
+func (s *Spool) Rewind() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	return s.rewind()
+}
+
+func (s *Spool) rewind() error {
+	if s.read == 0 {
+		return nil
+	}
+	s.read, s.readSize = 0, 0
+	if s.readSeg == 0 {
+		s.readOffset = s.offset
+		return nil
+	}
+	return s.openReader(0, s.offset)
+}
+
+// Len returns the number of the records not committed, It includes the records that will be dropped for the MaxAge.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.count
+}
+
+// Size returns the size of the records not committed, It is the size limited by the MaxSize.
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.size
+}
+
+// Close closes the spool, the records not committed are kept in the files.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	var err error
+	if s.tail != nil {
+		err = s.tail.Close()
+	}
+	if s.reader != nil {
+		if rerr := s.reader.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// readRecord reads the record at offset of f, It returns the size of the record.
+// The record must end before limit, it avoids allocating for a corrupted length.
+func readRecord(f *os.File, offset, limit int64) (int64, Record, error) {
+	var header [recordHeaderSize]byte
+	if _, err := f.ReadAt(header[:], offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, Record{}, io.EOF
+		}
+		return 0, Record{}, err
+	}
+	var (
+		ts     = int64(binary.BigEndian.Uint64(header[:]))
+		length = binary.BigEndian.Uint32(header[8:])
+		sum    = binary.BigEndian.Uint32(header[12:])
+	)
+	if offset+recordHeaderSize+int64(length) > limit {
+		return 0, Record{}, io.ErrUnexpectedEOF
+	}
+
+	data := make([]byte, length)
+	if _, err := f.ReadAt(data, offset+recordHeaderSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, Record{}, io.ErrUnexpectedEOF
+		}
+		return 0, Record{}, err
+	}
+	if crc32.ChecksumIEEE(data) != sum {
+		return 0, Record{}, errors.New("spool: record checksum mismatch")
+	}
+
+	return int64(recordHeaderSize) + int64(length), Record{Time: time.Unix(0, ts), Data: data}, nil
+}
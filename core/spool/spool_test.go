@@ -0,0 +1,206 @@
+package spool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+
+	// a small segment size makes every record in a segment.
+	s, err := Open(dir, Option{SegmentSize: 20})
+	assert.NoError(t, err)
+
+	for _, data := range []string{"a", "bb", "ccc"} {
+		assert.NoError(t, s.Append([]byte(data)))
+	}
+	assert.Equal(t, 3, s.Len())
+	assert.Equal(t, int64(3*recordHeaderSize+6), s.Size())
+
+	rec, ok, err := s.Peek()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "a", string(rec.Data))
+	assert.WithinDuration(t, time.Now(), rec.Time, time.Minute)
+
+	// the record is returned again until it is committed.
+	rec, _, _ = s.Peek()
+	assert.Equal(t, "a", string(rec.Data))
+	assert.NoError(t, s.Commit())
+	assert.ErrorIs(t, s.Commit(), ErrNoRecord)
+	assert.NoError(t, s.Close())
+
+	// the records not committed are replayed after reopening.
+	s, err = Open(dir, Option{SegmentSize: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, s.Len())
+
+	rec, _, _ = s.Peek()
+	assert.Equal(t, "bb", string(rec.Data))
+	assert.NoError(t, s.Commit())
+	assert.NoError(t, s.Append([]byte("dddd")))
+
+	var got []string
+	for {
+		rec, ok, err := s.Peek()
+		assert.NoError(t, err)
+		if !ok {
+			break
+		}
+		got = append(got, string(rec.Data))
+		assert.NoError(t, s.Commit())
+	}
+	assert.Equal(t, []string{"ccc", "dddd"}, got)
+	assert.Equal(t, 0, s.Len())
+
+	// the segments read are removed.
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.NoError(t, err)
+	assert.Len(t, segments, 1)
+	assert.NoError(t, s.Close())
+}
+
+func TestSpoolLimits(t *testing.T) {
+	s, err := Open(t.TempDir(), Option{SegmentSize: 20, MaxSize: 2 * (recordHeaderSize + 4)})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	assert.NoError(t, s.Append([]byte("1234")))
+	assert.NoError(t, s.Append([]byte("5678")))
+	assert.ErrorIs(t, s.Append([]byte("9")), ErrFull)
+
+	// the expired records are dropped, and they make room for the new ones.
+	s2, err := Open(t.TempDir(), Option{SegmentSize: 20, MaxSize: 2 * (recordHeaderSize + 4), MaxAge: 10 * time.Millisecond})
+	assert.NoError(t, err)
+	defer s2.Close()
+
+	assert.NoError(t, s2.Append([]byte("1234")))
+	assert.NoError(t, s2.Append([]byte("5678")))
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, s2.Append([]byte("9")))
+
+	rec, ok, err := s2.Peek()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "9", string(rec.Data))
+}
+
+func TestSpoolMaxSizeWithinSegment(t *testing.T) {
+	dir := t.TempDir()
+
+	// the records committed do not count against the MaxSize, though they are still in the segment.
+	s, err := Open(dir, Option{SegmentSize: 1 << 10, MaxSize: 2 * (recordHeaderSize + 4)})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, s.Append([]byte("1234")))
+		assert.NoError(t, s.Append([]byte("5678")))
+		assert.ErrorIs(t, s.Append([]byte("9")), ErrFull)
+
+		for {
+			_, ok, err := s.Peek()
+			assert.NoError(t, err)
+			if !ok {
+				break
+			}
+			assert.NoError(t, s.Commit())
+		}
+		assert.Equal(t, int64(0), s.Size())
+	}
+
+	// the drained segments are removed.
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.NoError(t, err)
+	assert.Len(t, segments, 1)
+}
+
+func TestSpoolNext(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, Option{SegmentSize: 20})
+	assert.NoError(t, err)
+
+	for _, data := range []string{"a", "bb", "ccc"} {
+		assert.NoError(t, s.Append([]byte(data)))
+	}
+	next := func() []string {
+		var got []string
+		for {
+			rec, ok, err := s.Next()
+			assert.NoError(t, err)
+			if !ok {
+				return got
+			}
+			got = append(got, string(rec.Data))
+		}
+	}
+
+	// the records read ahead are returned again after rewinding.
+	assert.Equal(t, []string{"a", "bb", "ccc"}, next())
+	assert.Equal(t, 3, s.Len())
+	assert.NoError(t, s.Rewind())
+	rec, ok, err := s.Next()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "a", string(rec.Data))
+	rec, _, _ = s.Next()
+	assert.Equal(t, "bb", string(rec.Data))
+
+	// the records read are committed together, and the cursor survives reopening.
+	assert.NoError(t, s.Commit())
+	assert.Equal(t, 1, s.Len())
+	assert.Equal(t, int64(recordHeaderSize+3), s.Size())
+	assert.NoError(t, s.Close())
+
+	s, err = Open(dir, Option{SegmentSize: 20})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, 1, s.Len())
+	assert.Equal(t, int64(recordHeaderSize+3), s.Size())
+	assert.Equal(t, []string{"ccc"}, next())
+	assert.NoError(t, s.Commit())
+	assert.ErrorIs(t, s.Commit(), ErrNoRecord)
+}
+
+func TestSpoolTornRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, Option{})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Append([]byte("complete")))
+	assert.NoError(t, s.Close())
+
+	// a crash leaves a part of the record.
+	path := filepath.Join(dir, "0000000000000000"+segmentExt)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 9, 'x'})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	s, err = Open(dir, Option{})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, 1, s.Len())
+	assert.NoError(t, s.Append([]byte("next")))
+
+	var got []string
+	for {
+		rec, ok, err := s.Peek()
+		assert.NoError(t, err)
+		if !ok {
+			break
+		}
+		got = append(got, string(rec.Data))
+		assert.NoError(t, s.Commit())
+	}
+	assert.Equal(t, []string{"complete", "next"}, got)
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"errors"
+	"io"
+
+	"github.com/quic-go/quic-go"
+)
+
+// ReadStream is the reading side of a tagged stream.
+type ReadStream interface {
+	io.Reader
+	// CancelRead aborts receiving on this stream,
+	// the writer of the stream will be aborted with the same error code.
+	CancelRead(quic.StreamErrorCode)
+}
+
+// WriteStream is the writing side of a tagged stream.
+type WriteStream interface {
+	io.WriteCloser
+	// CancelWrite aborts sending on this stream,
+	// the observers of the stream will be aborted with the same error code.
+	CancelWrite(quic.StreamErrorCode)
+}
+
+// streamCanceledCode is used to cancel the stream if the error is not caused by the peer canceling the stream,
+// for example, the connection of the peer is closed. It differs from RejectCodeClosed,
+// so the other side can tell a lost stream from a stream closed normally.
+const streamCanceledCode = quic.StreamErrorCode(RejectCodeCanceled)
+
+// copyStream copies src to dst until src reaches EOF, and propagates cancellation between them.
+// If the writer of src aborts, dst will be canceled with the same error code,
+// If the reader of dst stops reading, src will be canceled with the same error code.
+func copyStream(dst WriteStream, src ReadStream) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				src.CancelRead(streamErrorCode(werr))
+				return werr
+			}
+		}
+		if rerr == io.EOF {
+			return dst.Close()
+		}
+		if rerr != nil {
+			dst.CancelWrite(streamErrorCode(rerr))
+			return rerr
+		}
+	}
+}
+
+// streamErrorCode returns the error code of the stream error, it returns streamCanceledCode
+// if the err is not a stream error.
+func streamErrorCode(err error) quic.StreamErrorCode {
+	if serr := new(quic.StreamError); errors.As(err, &serr) {
+		return serr.ErrorCode
+	}
+	return streamCanceledCode
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// StreamHeader describes a tagged stream.
+// It is transmitted as an OpenStreamFrame at the beginning of every uniStream,
+// the server validates it and forwards it to the observers of the stream.
+type StreamHeader struct {
+	// ID is the identifier of the stream, It is generated by the writer.
+	ID string
+	// Tag is the tag of the stream, the stream is docked to the observers of the tag.
+	Tag string
+	// Metadata is the metadata that the writer carries with the stream.
+	Metadata metadata.MD
+}
+
+// validate validates the header.
+func (h *StreamHeader) validate() error {
+	if h.ID == "" {
+		return errors.New("stream header: id cannot be empty")
+	}
+	if h.Tag == "" {
+		return errors.New("stream header: tag cannot be empty")
+	}
+	return nil
+}
+
+// readStreamHeader reads the OpenStreamFrame at the beginning of r and converts it to StreamHeader.
+// The header is returned with the error if the OpenStreamFrame is read but invalid, so the stream can be
+// acknowledged to its writer by the ID.
+func readStreamHeader(frw *FrameReadWriter, r io.Reader) (*StreamHeader, error) {
+	f, err := frw.Readframe(r)
+	if err != nil {
+		return nil, err
+	}
+
+	of, ok := f.(*frame.OpenStreamFrame)
+	if !ok {
+		return nil, fmt.Errorf("stream header: read unexpected frame, frame read: %s", f.Type().String())
+	}
+
+	header := &StreamHeader{
+		ID:  of.ID,
+		Tag: of.Tag,
+	}
+
+	md := metadata.MD{}
+	if err := md.DecodeWithLimits(of.Metadata, frw.metadataLimits); err != nil {
+		return header, err
+	}
+	header.Metadata = md
+
+	return header, header.validate()
+}
+
+// writeStreamHeader writes the header to w as an OpenStreamFrame.
+func writeStreamHeader(frw *FrameReadWriter, w io.Writer, header *StreamHeader) error {
+	if err := header.validate(); err != nil {
+		return err
+	}
+	if err := frw.metadataLimits.Check(header.Metadata); err != nil {
+		return err
+	}
+
+	md, err := header.Metadata.Encode()
+	if err != nil {
+		return err
+	}
+
+	f := &frame.OpenStreamFrame{
+		ID:       header.ID,
+		Tag:      header.Tag,
+		Metadata: md,
+	}
+
+	return frw.WriteFrame(w, f)
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/frame"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+func TestStreamHeader(t *testing.T) {
+	frw := NewFrameReadWriter(frame.NewPacketCodec(0), frame.NewCodec())
+
+	var buf bytes.Buffer
+	header := &StreamHeader{ID: "1", Tag: "sensors", Metadata: metadata.MD{"unit": {"celsius"}}}
+	assert.NoError(t, writeStreamHeader(frw, &buf, header))
+	buf.WriteString("21.5")
+
+	got, err := readStreamHeader(frw, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, header, got)
+	assert.Equal(t, "21.5", buf.String())
+
+	for _, invalid := range []*StreamHeader{{Tag: "sensors"}, {ID: "1"}} {
+		assert.Error(t, writeStreamHeader(frw, &buf, invalid))
+	}
+
+	// the stream must begin with an OpenStreamFrame.
+	buf.Reset()
+	assert.NoError(t, frw.WriteFrame(&buf, &frame.ObserveFrame{Tag: "sensors"}))
+	_, err = readStreamHeader(frw, &buf)
+	assert.Error(t, err)
+}
+
+func TestStreamHeaderDelivery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := listenServer(t, ctx, nil)
+	observer := openTestClient(t, ctx, addr)
+	writer := openTestClient(t, ctx, addr, WithIDGenerator(func() string { return "stream-1" }))
+
+	streams, _ := observe(observer, "sensors")
+
+	w, err := writer.Open("sensors", metadata.MD{"unit": {"celsius"}, "zone": {"a", "b"}})
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("21.5"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	o := receive(t, streams)
+	assert.NoError(t, o.err)
+	assert.Equal(t, &StreamHeader{
+		ID:       "stream-1",
+		Tag:      "sensors",
+		Metadata: metadata.MD{"unit": {"celsius"}, "zone": {"a", "b"}},
+	}, o.header)
+	assert.Equal(t, "21.5", string(o.data))
+}
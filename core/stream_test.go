@@ -0,0 +1,158 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// cancelStream is a stream that records its cancellation, It reads a byte before readErr if data is set.
+type cancelStream struct {
+	data     bool
+	readErr  error
+	writeErr error
+	canceled *quic.StreamErrorCode
+}
+
+func (s *cancelStream) Read(p []byte) (int, error) {
+	if s.data {
+		s.data = false
+		p[0] = 'a'
+		return 1, nil
+	}
+	return 0, s.readErr
+}
+
+func (s *cancelStream) Write(p []byte) (int, error) {
+	if s.writeErr != nil {
+		return 0, s.writeErr
+	}
+	return len(p), nil
+}
+
+func (s *cancelStream) Close() error                          { return nil }
+func (s *cancelStream) CancelRead(code quic.StreamErrorCode)  { s.canceled = &code }
+func (s *cancelStream) CancelWrite(code quic.StreamErrorCode) { s.canceled = &code }
+
+func TestCopyStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst *cancelStream
+		// srcCanceled reports whether src is canceled rather than dst.
+		srcCanceled bool
+		code        quic.StreamErrorCode
+	}{
+		{
+			name: "writer cancels",
+			src:  &cancelStream{readErr: &quic.StreamError{ErrorCode: 42, Remote: true}},
+			dst:  &cancelStream{},
+			code: 42,
+		},
+		{
+			name: "writer is lost",
+			src:  &cancelStream{readErr: errors.New("timeout: no recent network activity")},
+			dst:  &cancelStream{},
+			code: streamCanceledCode,
+		},
+		{
+			name:        "observer cancels",
+			src:         &cancelStream{data: true, readErr: io.EOF},
+			dst:         &cancelStream{writeErr: &quic.StreamError{ErrorCode: 43, Remote: true}},
+			srcCanceled: true,
+			code:        43,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, copyStream(tt.dst, tt.src))
+
+			canceled := tt.dst
+			if tt.srcCanceled {
+				canceled = tt.src
+			}
+			if assert.NotNil(t, canceled.canceled) {
+				assert.Equal(t, tt.code, *canceled.canceled)
+			}
+		})
+	}
+	// a lost stream is not mistaken for a stream closed normally.
+	assert.NotEqual(t, RejectCodeClosed.StreamErrorCode(), streamCanceledCode)
+}
+
+func TestStreamCancellation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := listenServer(t, ctx, nil)
+
+	type accepted struct {
+		r    ReadStream
+		errc chan error
+	}
+	// accept observes a stream with a new observer, the server delivers one stream to an observation.
+	accept := func() <-chan accepted {
+		ch := make(chan accepted, 1)
+		go openTestClient(t, ctx, addr).Observe("sensors", ObserveHandleFunc(func(_ WriterOpener, _ *StreamHeader, r ReadStream) {
+			a := accepted{r: r, errc: make(chan error, 1)}
+			ch <- a
+			_, err := io.ReadAll(r)
+			a.errc <- err
+		}))
+		return ch
+	}
+	wait := func(ch <-chan accepted) accepted {
+		select {
+		case a := <-ch:
+			return a
+		case <-ctx.Done():
+			t.Fatal("the stream is not observed")
+			return accepted{}
+		}
+	}
+	open := func(c *Client) WriteStream {
+		w, err := c.Open("sensors", nil)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte("21.5"))
+		assert.NoError(t, err)
+		return w
+	}
+
+	// the writer cancels the stream, the observer receives the same code.
+	ch := accept()
+	w := open(openTestClient(t, ctx, addr))
+	a := wait(ch)
+	w.CancelWrite(42)
+	var serr *quic.StreamError
+	if assert.ErrorAs(t, <-a.errc, &serr) {
+		assert.Equal(t, quic.StreamErrorCode(42), serr.ErrorCode)
+	}
+
+	// the observer cancels the stream, the writer receives the same code.
+	ch = accept()
+	w = open(openTestClient(t, ctx, addr))
+	a = wait(ch)
+	a.r.CancelRead(43)
+	var err error
+	assert.Eventually(t, func() bool {
+		_, err = w.Write([]byte("22.0"))
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	if assert.ErrorAs(t, err, &serr) {
+		assert.Equal(t, quic.StreamErrorCode(43), serr.ErrorCode)
+	}
+
+	// the connection of the writer is lost, the stream is canceled rather than closed.
+	ch = accept()
+	writer := openTestClient(t, ctx, addr)
+	open(writer)
+	a = wait(ch)
+	writer.Close()
+	err = asRejectedError(<-a.errc)
+	assert.ErrorIs(t, err, RejectCodeCanceled)
+	assert.True(t, errors.Is(err, RejectCodeCanceled) && err.(*RejectedError).Temporary())
+}
@@ -0,0 +1,220 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+// TenantOption isolates tenants that share one server.
+// Every tag is prefixed with the tenant ID taken from the metadata of the client on the server side,
+// so clients from different tenants never see each other's streams even if they use the same tag.
+type TenantOption struct {
+	// MetadataKey is the key of the tenant ID in the metadata returned by authentication,
+	// The client without tenant ID will be rejected.
+	MetadataKey string
+	// MaxConnections limits the number of connections per tenant, 0 means no limit.
+	MaxConnections int
+	// MaxTags limits the number of distinct tags published or observed per tenant, 0 means no limit.
+	MaxTags int
+	// MaxBytesPerSecond limits the bandwidth of the streams published per tenant, 0 means no limit.
+	MaxBytesPerSecond int
+}
+
+// errNoTenant is returned when the tenant ID is not found in the metadata of client.
+var errNoTenant = errors.New("tenant: tenant id not found in metadata")
+
+// tenantSeparator separates the tenant ID and the tag, the tenant ID cannot contain it.
+const tenantSeparator = "/"
+
+// tenants tracks the tenants of a server and enforces the limits of them.
+type tenants struct {
+	option *TenantOption
+
+	mu      sync.Mutex
+	tenants map[string]*tenant
+}
+
+type tenant struct {
+	id    string
+	conns int
+	// tags is the tags used by the tenant,
+	// the key is the tag and the value is the number of connections using it.
+	tags    map[string]int
+	limiter *bandwidthLimiter
+}
+
+// tenantSession is the tenant state of a connection, a nil tenantSession means tenants are not isolated.
+type tenantSession struct {
+	tenants *tenants
+	tenant  *tenant
+	// tags is the tags used by the connection.
+	tags map[string]struct{}
+}
+
+func newTenants(option *TenantOption) *tenants {
+	if option == nil {
+		return nil
+	}
+	return &tenants{
+		option:  option,
+		tenants: make(map[string]*tenant),
+	}
+}
+
+// join joins the connection with md to its tenant.
+func (ts *tenants) join(md metadata.MD) (*tenantSession, error) {
+	if ts == nil {
+		return nil, nil
+	}
+
+	id, ok := md.Get(ts.option.MetadataKey)
+	if !ok || id == "" {
+		return nil, errNoTenant
+	}
+	if strings.Contains(id, tenantSeparator) {
+		return nil, fmt.Errorf("%w: tenant id cannot contain %q", errNoTenant, tenantSeparator)
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	t, ok := ts.tenants[id]
+	if !ok {
+		t = &tenant{
+			id:      id,
+			tags:    make(map[string]int),
+			limiter: newBandwidthLimiter(ts.option.MaxBytesPerSecond),
+		}
+		ts.tenants[id] = t
+	}
+	if max := ts.option.MaxConnections; max > 0 && t.conns >= max {
+		return nil, fmt.Errorf("tenant: tenant %s exceeds the limit of %d connections", id, max)
+	}
+	t.conns++
+
+	session := &tenantSession{
+		tenants: ts,
+		tenant:  t,
+		tags:    make(map[string]struct{}),
+	}
+
+	return session, nil
+}
+
+// leave releases the connection and the tags it used from its tenant.
+func (s *tenantSession) leave() {
+	if s == nil {
+		return
+	}
+
+	s.tenants.mu.Lock()
+	defer s.tenants.mu.Unlock()
+
+	for tag := range s.tags {
+		if s.tenant.tags[tag]--; s.tenant.tags[tag] <= 0 {
+			delete(s.tenant.tags, tag)
+		}
+	}
+	if s.tenant.conns--; s.tenant.conns <= 0 {
+		delete(s.tenants.tenants, s.tenant.id)
+	}
+}
+
+// useTag records that the connection uses the tag, It returns an error if the tenant exceeds the limit of tags.
+func (s *tenantSession) useTag(tag string) error {
+	if s == nil {
+		return nil
+	}
+
+	s.tenants.mu.Lock()
+	defer s.tenants.mu.Unlock()
+
+	if _, ok := s.tags[tag]; ok {
+		return nil
+	}
+	t := s.tenant
+	if max := s.tenants.option.MaxTags; max > 0 && t.tags[tag] == 0 && len(t.tags) >= max {
+		return fmt.Errorf("tenant: tenant %s exceeds the limit of %d tags", t.id, max)
+	}
+	t.tags[tag]++
+	s.tags[tag] = struct{}{}
+
+	return nil
+}
+
+// namespace prefixes the tag with the tenant ID.
+func (s *tenantSession) namespace(tag string) string {
+	if s == nil {
+		return tag
+	}
+	return s.tenant.id + tenantSeparator + tag
+}
+
+// limit limits the bandwidth of the stream with the limit of the tenant.
+func (s *tenantSession) limit(r ReadStream) ReadStream {
+	if s == nil || s.tenant.limiter == nil {
+		return r
+	}
+	return WrapReadStream(r, &limitedReader{r: r, limiter: s.tenant.limiter})
+}
+
+// bandwidthLimiter is a token bucket that limits the bytes per second, the burst is one second.
+type bandwidthLimiter struct {
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newBandwidthLimiter(bytesPerSecond int) *bandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// wait takes n tokens from the bucket and blocks until the tokens are refilled if they are not enough.
+func (l *bandwidthLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+type limitedReader struct {
+	r       io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	// reading no more than the rate avoids the bursts bigger than one second.
+	if max := int(r.limiter.rate); len(p) > max {
+		p = p[:max]
+	}
+	n, err := r.r.Read(p)
+	r.limiter.wait(n)
+	return n, err
+}
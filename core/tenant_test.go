@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/auth"
+	"github.com/woorui/ydesign/core/metadata"
+)
+
+func TestTenants(t *testing.T) {
+	ts := newTenants(&TenantOption{MetadataKey: "tenant", MaxConnections: 2, MaxTags: 2})
+
+	// the client without a valid tenant ID is rejected.
+	_, err := ts.join(metadata.MD{})
+	assert.ErrorIs(t, err, errNoTenant)
+	_, err = ts.join(metadata.MD{"tenant": {"a/b"}})
+	assert.ErrorIs(t, err, errNoTenant)
+
+	a1, err := ts.join(metadata.MD{"tenant": {"a"}})
+	assert.NoError(t, err)
+	a2, err := ts.join(metadata.MD{"tenant": {"a"}})
+	assert.NoError(t, err)
+	_, err = ts.join(metadata.MD{"tenant": {"a"}})
+	assert.Error(t, err)
+
+	// the limits are per tenant.
+	b, err := ts.join(metadata.MD{"tenant": {"b"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "a/sensors", a1.namespace("sensors"))
+	assert.Equal(t, "b/sensors", b.namespace("sensors"))
+
+	// the tags used by the connections of a tenant count once.
+	assert.NoError(t, a1.useTag("sensors"))
+	assert.NoError(t, a2.useTag("sensors"))
+	assert.NoError(t, a2.useTag("logs"))
+	assert.Error(t, a1.useTag("alerts"))
+	assert.NoError(t, b.useTag("alerts"))
+
+	// the connection leaving releases its tags and its connection.
+	a2.leave()
+	assert.NoError(t, a1.useTag("alerts"))
+	_, err = ts.join(metadata.MD{"tenant": {"a"}})
+	assert.NoError(t, err)
+
+	// a nil session means the tenants are not isolated.
+	var none *tenantSession
+	assert.Nil(t, newTenants(nil))
+	assert.NoError(t, none.useTag("sensors"))
+	assert.Equal(t, "sensors", none.namespace("sensors"))
+	none.leave()
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	assert.Nil(t, newBandwidthLimiter(0))
+
+	// the burst is one second, and the rest waits for the tokens.
+	l := newBandwidthLimiter(1000)
+	start := time.Now()
+	l.wait(1000)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+	l.wait(100)
+	assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	registry := auth.NewRegistry()
+	registry.Register(auth.NewTokenAuth(
+		auth.Token{Token: "a", Metadata: metadata.MD{"tenant": {"a"}}},
+		auth.Token{Token: "b", Metadata: metadata.MD{"tenant": {"b"}}},
+		auth.Token{Token: "none"},
+	))
+	addr := listenServer(t, ctx, &ServerOption{
+		Auth:   registry,
+		Tenant: &TenantOption{MetadataKey: "tenant", MaxConnections: 2, MaxTags: 1},
+	})
+
+	observerA := openTestClient(t, ctx, addr, WithCredential("token:a"))
+	observerB := openTestClient(t, ctx, addr, WithCredential("token:b"))
+	streamsA, _ := observe(observerA, "sensors")
+	streamsB, _ := observe(observerB, "sensors")
+
+	// the streams of a tenant are docked to the observers of the same tenant only.
+	for _, tenant := range []string{"b", "a"} {
+		w, err := openTestClient(t, ctx, addr, WithCredential("token:"+tenant)).Open("sensors", nil)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(tenant))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+	}
+	assert.Equal(t, "a", string(receive(t, streamsA).data))
+	assert.Equal(t, "b", string(receive(t, streamsB).data))
+
+	// the tenant exceeds the limit of tags.
+	_, errCh := observe(observerA, "logs")
+	select {
+	case err := <-errCh:
+		assert.ErrorIs(t, err, RejectCodeLimitExceeded)
+	case <-ctx.Done():
+		t.Fatal("the observing exceeding the limit is not rejected")
+	}
+
+	// the tenant exceeds the limit of connections, and the client without tenant is forbidden.
+	// The client is rejected after it is authenticated, so the rejection may be returned by OpenClient.
+	for cred, code := range map[string]RejectCode{"token:a": RejectCodeLimitExceeded, "token:none": RejectCodeForbidden} {
+		client, err := OpenClient(ctx, addr, WithTLSConfig(insecureTLSConfig()), WithCredential(cred))
+		if err == nil {
+			_, errCh := observe(client, "sensors")
+			select {
+			case err = <-errCh:
+			case <-ctx.Done():
+				t.Fatal("the client is not rejected", cred)
+			}
+			client.Close()
+		}
+		var rerr *RejectedError
+		assert.True(t, errors.As(err, &rerr), cred)
+		assert.ErrorIs(t, err, code, cred)
+	}
+}
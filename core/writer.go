@@ -0,0 +1,531 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/woorui/ydesign/core/metadata"
+	"github.com/woorui/ydesign/core/spool"
+)
+
+// DefaultWriterBufferSize is the default number of bytes a Writer buffers while it is disconnected.
+const DefaultWriterBufferSize = 1 << 20
+
+const (
+	// ReplayKey is the metadata key that marks the streams replaying the spooled data, Its value is "true".
+	// The observers read it with `md.Bool(core.ReplayKey)`.
+	ReplayKey = "spool_replay"
+	// SpooledAtKey is the metadata key of the time when the first data replayed in the stream is spooled.
+	SpooledAtKey = "spool_spooled_at"
+)
+
+var (
+	// ErrWriterBufferFull is returned when the data written exceeds the buffer of a Writer, It is terminal.
+	ErrWriterBufferFull = errors.New("client: writer buffer is full")
+	// ErrWriterClosed is returned when writing to a closed Writer.
+	ErrWriterClosed = errors.New("client: writer is closed")
+	// errStreamLost means the connection is lost before the stream is acknowledged, so it may not be delivered.
+	errStreamLost = errors.New("client: the stream is lost before it is acknowledged")
+)
+
+// WriterOption is the option of Writer.
+type WriterOption struct {
+	// BufferSize is the number of bytes buffered while the writer is disconnected or the data is not sent yet,
+	// It is DefaultWriterBufferSize if it is 0.
+	BufferSize int
+	// Spool stores the data written while the writer is disconnected or the buffer is full, and the data is
+	// replayed in order after reconnecting. The data in the spool when the writer is created is replayed first.
+	// A record is removed only after the server acknowledges that the stream replaying it is delivered to
+	// an observer, so it stays on disk while the stream waits in the server for the tag to be observed.
+	// The spool should not be shared by the writers, and it is not closed with the writer.
+	Spool *spool.Spool
+}
+
+// Writer is the io.WriteCloser of a tag that survives the reconnections of the client,
+// It buffers the data while the client is reconnecting and re-opens the stream on the new connection.
+// The data of a Write is never split across streams.
+//
+// The data written to a stream is kept until the server acknowledges that the stream is delivered to an observer,
+// and it is sent again on the new stream if the stream is lost or canceled, so the observers may receive it twice.
+// A stream is closed only when the writer switches between the buffer and the spool or the writer is closed,
+// and the next stream is not opened before it is acknowledged. The server keeps the stream of a tag not observed
+// until an observer comes, so Close waits for it. The oldest data kept is released when the buffer is full,
+// it may be lost if the stream is lost after that.
+//
+// Writer reports a terminal error only when its context ends, the buffer overflows
+// or the server rejects the stream permanently.
+//
+// If the spool is set, the data that does not fit in the buffer is appended to the spool instead,
+// and it is replayed in the streams whose metadata has ReplayKey set. The data replayed is committed
+// in the spool after the stream is acknowledged, and it is replayed again if the stream is lost.
+// The buffer is moved to the spool if the context ends before it is delivered, after the records in the spool
+// if it is not empty, and Close returns the error if it cannot be spooled.
+type Writer struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	client *Client
+	tag    string
+	md     metadata.MD
+	limit  int
+	spool  *spool.Spool
+
+	// mu guards the fields below.
+	mu sync.Mutex
+	// chunks is the data not sent yet, in the order of writing.
+	chunks [][]byte
+	size   int
+	// inflight is the chunks written to the current stream, they are sent again if the stream is lost.
+	inflight     [][]byte
+	inflightSize int
+	closed       bool
+	// offline is true while the writer waits for reconnecting.
+	offline bool
+	// err is the terminal error.
+	err error
+
+	// wake is signaled when a chunk is written or the writer is closed.
+	wake chan struct{}
+	// done is closed when the sending loop exits.
+	done chan struct{}
+}
+
+// NewWriter returns a Writer that writes to the streams of tag, the md is carried in the header of every stream opened.
+// The writer stops when ctx ends, the data not delivered yet is moved to the spool if it is set, otherwise it is dropped.
+func (c *Client) NewWriter(ctx context.Context, tag string, md metadata.MD, option *WriterOption) (*Writer, error) {
+	if option == nil {
+		option = &WriterOption{}
+	}
+	limit := option.BufferSize
+	if limit == 0 {
+		limit = DefaultWriterBufferSize
+	}
+	if limit < 0 {
+		return nil, errors.New("client: writer buffer size cannot be negative")
+	}
+	// fail fast, the metadata is not changed across the streams opened and the signature has the same size for every stream.
+	if _, err := c.streamMetadata(tag, "", md); err != nil {
+		return nil, err
+	}
+	if option.Spool != nil {
+		if _, err := c.streamMetadata(tag, "", replayMetadata(md, time.Now())); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	w := &Writer{
+		ctx:    ctx,
+		cancel: cancel,
+		client: c,
+		tag:    tag,
+		md:     md.Clone(),
+		limit:  limit,
+		spool:  option.Spool,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+
+	return w, nil
+}
+
+// Write buffers p to be sent, It does not wait for p to be sent.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	w.release(w.size + w.inflightSize + len(p) - w.limit)
+	// keep the order, the data is spooled until the spool is drained.
+	if w.spool != nil && (w.offline || w.spool.Len() > 0 || w.size+len(p) > w.limit) {
+		if err := w.spill(p); err != nil {
+			w.fail(err)
+			return 0, err
+		}
+		w.signal()
+		return len(p), nil
+	}
+	if w.size+len(p) > w.limit {
+		w.fail(ErrWriterBufferFull)
+		return 0, ErrWriterBufferFull
+	}
+
+	w.chunks = append(w.chunks, append([]byte(nil), p...))
+	w.size += len(p)
+	w.signal()
+
+	return len(p), nil
+}
+
+// Close sends the buffered data and closes the stream, It waits until the data is delivered or the context ends.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.signal()
+	w.mu.Unlock()
+
+	<-w.done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if errors.Is(w.err, ErrWriterClosed) {
+		return nil
+	}
+	return w.err
+}
+
+// spill appends p to the spool, the buffer except the chunk being sent is moved to the spool first,
+// so the buffer is never newer than the spool. It must be called with mu held.
+func (w *Writer) spill(p []byte) error {
+	if len(w.chunks) > 1 {
+		for _, chunk := range w.chunks[1:] {
+			if err := w.spool.Append(chunk); err != nil {
+				return err
+			}
+			w.size -= len(chunk)
+		}
+		w.chunks = w.chunks[:1]
+	}
+	return w.spool.Append(p)
+}
+
+// replayMetadata returns the metadata of the streams replaying the data spooled at spooledAt.
+func replayMetadata(md metadata.MD, spooledAt time.Time) metadata.MD {
+	md = md.Clone()
+	if md == nil {
+		md = metadata.MD{}
+	}
+	md.SetBool(ReplayKey, true)
+	md.SetTime(SpooledAtKey, spooledAt)
+
+	return md
+}
+
+// release releases the oldest inflight chunks until n bytes are released or there is no inflight chunk,
+// It must be called with mu held.
+func (w *Writer) release(n int) {
+	for n > 0 && len(w.inflight) > 0 {
+		n -= len(w.inflight[0])
+		w.inflightSize -= len(w.inflight[0])
+		w.inflight[0] = nil
+		w.inflight = w.inflight[1:]
+	}
+}
+
+// requeue puts the inflight chunks back to the front of the buffer and rewinds the spool when the stream is lost,
+// It must be called with mu held.
+func (w *Writer) requeue() {
+	if w.spool != nil {
+		if err := w.spool.Rewind(); err != nil {
+			w.fail(err)
+		}
+	}
+	if len(w.inflight) == 0 {
+		return
+	}
+	w.chunks = append(w.inflight, w.chunks...)
+	w.size += w.inflightSize
+	w.inflight, w.inflightSize = nil, 0
+}
+
+// Buffered returns the number of bytes in the buffer that are not sent yet,
+// It does not include the data in the spool and the data kept for the current stream.
+func (w *Writer) Buffered() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.size
+}
+
+func (w *Writer) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// fail sets the terminal error and stops the sending loop, it must be called with mu held.
+func (w *Writer) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+	w.cancel()
+}
+
+// pending is the data to be sent, it is a chunk of the buffer or a record of the spool.
+// The data is nil if the records of the spool are all sent, and the replay stream should be closed to commit them.
+type pending struct {
+	data   []byte
+	replay bool
+	// spooledAt is the time when the record is spooled.
+	spooledAt time.Time
+}
+
+// next returns the data not sent yet, the buffer is sent before the spool because it is never newer.
+// It returns the nil data to close the open stream after the writer is closed and drained,
+// and it returns false if the writer is closed and drained without an open stream or ctx ends.
+func (w *Writer) next(open bool) (pending, bool) {
+	for {
+		w.mu.Lock()
+		if err := w.ctx.Err(); err != nil {
+			w.fail(err)
+		}
+		if w.err != nil {
+			w.mu.Unlock()
+			return pending{}, false
+		}
+		if len(w.chunks) > 0 {
+			chunk := w.chunks[0]
+			w.mu.Unlock()
+			return pending{data: chunk}, true
+		}
+		if w.spool != nil && w.spool.Len() > 0 {
+			rec, ok, err := w.spool.Next()
+			if err != nil {
+				w.fail(err)
+				w.mu.Unlock()
+				return pending{}, false
+			}
+			sent := w.spool.Len() > 0
+			w.mu.Unlock()
+			if ok {
+				return pending{data: rec.Data, replay: true, spooledAt: rec.Time}, true
+			}
+			if sent {
+				return pending{replay: true}, true
+			}
+			// the records are expired and dropped.
+			continue
+		}
+		if w.closed && open {
+			w.mu.Unlock()
+			return pending{}, true
+		}
+		if w.closed {
+			w.fail(ErrWriterClosed)
+			w.mu.Unlock()
+			return pending{}, false
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-w.wake:
+		case <-w.ctx.Done():
+		}
+	}
+}
+
+// sent removes the data which is sent.
+func (w *Writer) sent(p pending) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.offline = false
+	if p.replay {
+		// the record is committed after the stream is closed.
+		return nil
+	}
+	chunk := w.chunks[0]
+	w.size -= len(chunk)
+	w.chunks[0] = nil
+	w.chunks = w.chunks[1:]
+	w.inflight = append(w.inflight, chunk)
+	w.inflightSize += len(chunk)
+
+	return nil
+}
+
+// closeStream closes the stream with the ID and waits for the server to acknowledge it, or lost is closed.
+// The chunks written to the stream are released and the records replayed are committed after it is delivered,
+// otherwise they are put back to be sent again and the reason is returned.
+func (w *Writer) closeStream(stream WriteStream, id string, lost <-chan struct{}, replay bool) error {
+	var (
+		acked <-chan *RejectedError
+		stop  = func() {}
+	)
+	if w.client.acks != nil {
+		// wait before closing, the acknowledgement may arrive before Close returns.
+		acked, stop = w.client.acks.wait(id)
+	}
+	defer stop()
+
+	err := stream.Close()
+	if err == nil && acked != nil {
+		select {
+		case rerr := <-acked:
+			if rerr != nil {
+				err = rerr
+			}
+		case <-lost:
+			err = errStreamLost
+		case <-w.ctx.Done():
+			err = w.ctx.Err()
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err != nil {
+		w.requeue()
+		return err
+	}
+	w.release(w.inflightSize)
+	if replay {
+		if err := w.spool.Commit(); err != nil {
+			w.fail(err)
+		}
+	}
+	return nil
+}
+
+// run sends the data in order, It re-opens the stream if the stream or the connection is lost.
+// The data of the spool is sent in the streams marked by ReplayKey.
+func (w *Writer) run() {
+	defer close(w.done)
+
+	var (
+		stream  WriteStream
+		id      string
+		lost    <-chan struct{}
+		replay  bool
+		attempt int
+	)
+	for {
+		p, ok := w.next(stream != nil)
+		if !ok {
+			break
+		}
+		// switch the stream between the buffer and the spool, commit the records replayed, or close the writer.
+		if stream != nil && (replay != p.replay || p.data == nil) {
+			err := w.closeStream(stream, id, lost, replay)
+			stream = nil
+			if err != nil {
+				w.client.option.Logger.Debug("writer stream is not delivered", "tag", w.tag, "stream_id", id, "err", err)
+				w.retry(err, &attempt)
+				continue
+			}
+			if p.data != nil {
+				// p is read again for the new stream.
+				w.mu.Lock()
+				w.requeue()
+				w.mu.Unlock()
+			}
+			continue
+		}
+		if stream == nil {
+			md := w.md
+			if p.replay {
+				md = replayMetadata(md, p.spooledAt)
+			}
+			// get the connection before opening, so the stream is never on a newer connection than lost tells.
+			lost = w.client.connDone()
+			s, sid, err := w.client.open(w.tag, md)
+			if err != nil {
+				w.mu.Lock()
+				w.requeue()
+				w.mu.Unlock()
+				w.retry(err, &attempt)
+				continue
+			}
+			stream, id, replay = s, sid, p.replay
+			w.client.option.Logger.Debug("writer opens the stream", "tag", w.tag, "stream_id", id, "replay", replay, "attempt", attempt)
+		}
+		if _, err := stream.Write(p.data); err != nil {
+			stream.CancelWrite(streamErrorCode(err))
+			stream = nil
+			w.mu.Lock()
+			w.requeue()
+			w.mu.Unlock()
+			w.retry(asRejectedError(err), &attempt)
+			continue
+		}
+		attempt = 0
+		if err := w.sent(p); err != nil {
+			w.mu.Lock()
+			w.fail(err)
+			w.mu.Unlock()
+		}
+	}
+
+	w.mu.Lock()
+	if !errors.Is(w.err, ErrWriterClosed) {
+		// the stream is canceled, so the data written to it is not delivered.
+		w.requeue()
+	}
+	w.persist()
+	w.mu.Unlock()
+
+	// the stream is closed in the loop if the writer is closed.
+	if stream != nil {
+		stream.CancelWrite(streamCanceledCode)
+	}
+}
+
+// persist moves the buffer to the spool when the writer stops before the buffer is delivered.
+// The buffer is older than the records in the spool, so it is replayed after them if the spool is not empty.
+// The terminal error reports the buffer that is not spooled. It must be called with mu held.
+func (w *Writer) persist() {
+	if w.spool == nil || len(w.chunks) == 0 || errors.Is(w.err, ErrWriterClosed) {
+		return
+	}
+	if w.spool.Len() > 0 {
+		w.client.option.Logger.Debug("writer spools the buffer after the spooled records", "tag", w.tag, "size", w.size)
+	}
+	for len(w.chunks) > 0 {
+		chunk := w.chunks[0]
+		if err := w.spool.Append(chunk); err != nil {
+			w.err = errors.Join(w.err, fmt.Errorf("client: writer failed to spool %d bytes: %w", w.size, err))
+			return
+		}
+		w.size -= len(chunk)
+		w.chunks[0] = nil
+		w.chunks = w.chunks[1:]
+	}
+}
+
+// retry waits for the client to be ready to re-open the stream, or fails the writer if err can not be recovered.
+func (w *Writer) retry(err error, attempt *int) {
+	rc, ok := w.client.conn.(*reconnectingConn)
+	if !ok || !reconnectable(err) {
+		w.mu.Lock()
+		w.fail(err)
+		w.mu.Unlock()
+		return
+	}
+	w.client.option.Logger.Debug("writer waits for reconnecting", "tag", w.tag, "err", err)
+
+	w.mu.Lock()
+	w.offline = true
+	w.mu.Unlock()
+
+	// back off, so that a stream rejected temporarily is not re-opened immediately.
+	timer := time.NewTimer(w.client.option.Reconnect.backoff(*attempt))
+	defer timer.Stop()
+	*attempt++
+
+	select {
+	case <-timer.C:
+	case <-w.ctx.Done():
+		return
+	}
+	if err := rc.ready(w.ctx); err != nil && w.ctx.Err() == nil {
+		w.mu.Lock()
+		w.fail(err)
+		w.mu.Unlock()
+	}
+}
@@ -0,0 +1,493 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/woorui/ydesign/core/acl"
+	"github.com/woorui/ydesign/core/metadata"
+	"github.com/woorui/ydesign/core/spool"
+)
+
+func TestWriter(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		ctrls []*fakeController
+		down  = make(chan struct{})
+	)
+	dial := func(ctx context.Context, addr string, option *ClientOption) (ClientController, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// the reconnection succeeds after the test allows it.
+		if len(ctrls) > 0 {
+			select {
+			case <-down:
+			default:
+				return nil, errors.New("network is unreachable")
+			}
+		}
+		ctrl := newFakeController(string(rune('a' + len(ctrls))))
+		ctrls = append(ctrls, ctrl)
+		return ctrl, nil
+	}
+	ctrl := func(i int) *fakeController {
+		mu.Lock()
+		defer mu.Unlock()
+		if i >= len(ctrls) {
+			return nil
+		}
+		return ctrls[i]
+	}
+
+	option, err := NewClientOption(WithReconnect(&ReconnectOption{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}))
+	assert.NoError(t, err)
+	rc, err := openReconnectingConn(context.Background(), "localhost:9000", option, dial)
+	assert.NoError(t, err)
+	client, err := NewClient(rc, option)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	w, err := client.NewWriter(context.Background(), "sensors", metadata.MD{"unit": {"celsius"}}, &WriterOption{BufferSize: 8})
+	assert.NoError(t, err)
+
+	_, err = w.Write([]byte("21.5"))
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return w.Buffered() == 0 }, time.Second, time.Millisecond)
+
+	// the data written while reconnecting is buffered and sent on the new connection.
+	ctrl(0).lose(errors.New("timeout: no recent network activity"))
+	assert.Eventually(t, func() bool { return rc.State() == ConnStateReconnecting }, time.Second, time.Millisecond)
+
+	_, err = w.Write([]byte("22.0"))
+	assert.NoError(t, err)
+	close(down)
+
+	assert.NoError(t, w.Close())
+	_, err = w.Write([]byte("22.5"))
+	assert.ErrorIs(t, err, ErrWriterClosed)
+
+	// the data written to the lost stream is sent again on the new connection.
+	for i, want := range []struct {
+		data   string
+		closed bool
+	}{{"21.5", false}, {"21.522.0", true}} {
+		streams := ctrl(i).openedStreams()
+		assert.Len(t, streams, 1)
+		header, data, closed := streams[0].data(client.frw)
+		assert.Equal(t, "sensors", header.Tag)
+		assert.Equal(t, metadata.MD{"unit": {"celsius"}}, header.Metadata)
+		assert.Equal(t, want.data, data)
+		assert.Equal(t, want.closed, closed)
+	}
+}
+
+func TestWriterStreamLost(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		ctrls []*fakeController
+	)
+	dial := func(ctx context.Context, addr string, option *ClientOption) (ClientController, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		ctrl := newFakeController(string(rune('a' + len(ctrls))))
+		ctrls = append(ctrls, ctrl)
+		return ctrl, nil
+	}
+	ctrl := func(i int) *fakeController {
+		mu.Lock()
+		defer mu.Unlock()
+		return ctrls[i]
+	}
+
+	option, err := NewClientOption(WithReconnect(&ReconnectOption{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}))
+	assert.NoError(t, err)
+	rc, err := openReconnectingConn(context.Background(), "localhost:9000", option, dial)
+	assert.NoError(t, err)
+	client, err := NewClient(rc, option)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	w, err := client.NewWriter(context.Background(), "sensors", nil, &WriterOption{BufferSize: 6})
+	assert.NoError(t, err)
+
+	write := func(data string) {
+		_, err := w.Write([]byte(data))
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool { return w.Buffered() == 0 }, time.Second, time.Millisecond)
+	}
+	// the oldest data written to the stream is released when the buffer is full.
+	for _, data := range []string{"12", "34", "56", "78"} {
+		write(data)
+	}
+	_, data, _ := ctrl(0).openedStreams()[0].data(client.frw)
+	assert.Equal(t, "12345678", data)
+
+	// the stream is lost in the middle, the data kept for it is sent again before the following data,
+	// except "34" which is released for "90".
+	ctrl(0).lose(errors.New("timeout: no recent network activity"))
+	_, err = w.Write([]byte("90"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	streams := ctrl(1).openedStreams()
+	assert.Len(t, streams, 1)
+	_, data, closed := streams[0].data(client.frw)
+	assert.Equal(t, "567890", data)
+	assert.True(t, closed)
+}
+
+func TestWriterAck(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		ctrls []*fakeController
+	)
+	dial := func(ctx context.Context, addr string, option *ClientOption) (ClientController, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		ctrl := newFakeController(string(rune('a' + len(ctrls))))
+		// the first connection never acknowledges the streams.
+		if len(ctrls) == 0 {
+			ctrl.stopAcking()
+		}
+		ctrls = append(ctrls, ctrl)
+		return ctrl, nil
+	}
+	ctrl := func(i int) *fakeController {
+		mu.Lock()
+		defer mu.Unlock()
+		return ctrls[i]
+	}
+
+	option, err := NewClientOption(WithReconnect(&ReconnectOption{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}))
+	assert.NoError(t, err)
+	rc, err := openReconnectingConn(context.Background(), "localhost:9000", option, dial)
+	assert.NoError(t, err)
+	client, err := NewClient(rc, option)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	w, err := client.NewWriter(context.Background(), "sensors", nil, nil)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("21.5"))
+	assert.NoError(t, err)
+
+	// Close waits for the stream closed to be acknowledged.
+	closed := make(chan error, 1)
+	go func() { closed <- w.Close() }()
+	assert.Eventually(t, func() bool {
+		streams := ctrl(0).openedStreams()
+		if len(streams) != 1 {
+			return false
+		}
+		_, _, closed := streams[0].data(client.frw)
+		return closed
+	}, time.Second, time.Millisecond)
+	select {
+	case <-closed:
+		t.Fatal("the writer is closed before the stream is acknowledged")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the connection is lost before the acknowledgement, so the data is sent again on the new connection.
+	ctrl(0).lose(errors.New("timeout: no recent network activity"))
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the writer is not closed after the stream is acknowledged")
+	}
+
+	streams := ctrl(1).openedStreams()
+	assert.Len(t, streams, 1)
+	_, data, ok := streams[0].data(client.frw)
+	assert.Equal(t, "21.5", data)
+	assert.True(t, ok)
+}
+
+func TestWriterDelivery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rules, err := acl.Parse("* may observe *", "* may publish sensors")
+	assert.NoError(t, err)
+	addr := listenServer(t, ctx, &ServerOption{ACL: rules})
+	writer := openTestClient(t, ctx, addr)
+
+	// the stream waits in the server until the tag is observed, and the writer waits for it.
+	w, err := writer.NewWriter(ctx, "sensors", nil, nil)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("21.5"))
+	assert.NoError(t, err)
+	closed := make(chan error, 1)
+	go func() { closed <- w.Close() }()
+
+	select {
+	case <-closed:
+		t.Fatal("the writer is closed before the stream is delivered")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	streams, _ := observe(openTestClient(t, ctx, addr), "sensors")
+	assert.Equal(t, "21.5", string(receive(t, streams).data))
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("the writer is not closed after the stream is delivered")
+	}
+
+	// the stream rejected after it is closed fails the writer.
+	w, err = writer.NewWriter(ctx, "secret", nil, nil)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("21.5"))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, w.Close(), RejectCodeForbidden)
+}
+
+func TestWriterBufferFull(t *testing.T) {
+	first := newFakeController("a")
+	ctrl := first
+	dial := func(ctx context.Context, addr string, option *ClientOption) (ClientController, error) {
+		if ctrl != nil {
+			c := ctrl
+			ctrl = nil
+			return c, nil
+		}
+		return nil, errors.New("network is unreachable")
+	}
+
+	option, err := NewClientOption(WithReconnect(nil))
+	assert.NoError(t, err)
+	rc, err := openReconnectingConn(context.Background(), "localhost:9000", option, dial)
+	assert.NoError(t, err)
+	client, err := NewClient(rc, option)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	first.lose(errors.New("timeout: no recent network activity"))
+	assert.Eventually(t, func() bool { return rc.State() == ConnStateReconnecting }, time.Second, time.Millisecond)
+
+	w, err := client.NewWriter(context.Background(), "sensors", nil, &WriterOption{BufferSize: 4})
+	assert.NoError(t, err)
+
+	_, err = w.Write([]byte("1234"))
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("5"))
+	assert.ErrorIs(t, err, ErrWriterBufferFull)
+	assert.ErrorIs(t, w.Close(), ErrWriterBufferFull)
+
+	// the writer stops when the context ends.
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err = client.NewWriter(ctx, "sensors", nil, nil)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("1"))
+	assert.NoError(t, err)
+	cancel()
+	assert.ErrorIs(t, w.Close(), context.Canceled)
+}
+
+func TestWriterSpool(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		ctrls []*fakeController
+		allow bool
+	)
+	dial := func(ctx context.Context, addr string, option *ClientOption) (ClientController, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(ctrls) > 0 && !allow {
+			return nil, errors.New("network is unreachable")
+		}
+		ctrl := newFakeController(string(rune('a' + len(ctrls))))
+		ctrls = append(ctrls, ctrl)
+		return ctrl, nil
+	}
+	ctrl := func(i int) *fakeController {
+		mu.Lock()
+		defer mu.Unlock()
+		return ctrls[i]
+	}
+	setAllow := func(v bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		allow = v
+	}
+
+	option, err := NewClientOption(WithReconnect(&ReconnectOption{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}))
+	assert.NoError(t, err)
+	rc, err := openReconnectingConn(context.Background(), "localhost:9000", option, dial)
+	assert.NoError(t, err)
+	client, err := NewClient(rc, option)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	sp, err := spool.Open(t.TempDir(), spool.Option{})
+	assert.NoError(t, err)
+	defer sp.Close()
+
+	ctrl(0).lose(errors.New("timeout: no recent network activity"))
+	assert.Eventually(t, func() bool { return rc.State() == ConnStateReconnecting }, time.Second, time.Millisecond)
+
+	// the data that does not fit in the buffer is spooled.
+	w, err := client.NewWriter(context.Background(), "sensors", nil, &WriterOption{BufferSize: 1, Spool: sp})
+	assert.NoError(t, err)
+	for _, data := range []string{"1", "2", "3"} {
+		_, err = w.Write([]byte(data))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, sp.Len())
+
+	setAllow(true)
+	assert.NoError(t, w.Close())
+	assert.Equal(t, 0, sp.Len())
+
+	streams := ctrl(1).openedStreams()
+	assert.Len(t, streams, 2)
+
+	header, data, closed := streams[0].data(client.frw)
+	assert.Equal(t, "1", data)
+	assert.True(t, closed)
+	_, err = header.Metadata.Bool(ReplayKey)
+	assert.ErrorIs(t, err, metadata.ErrKeyNotFound)
+
+	header, data, closed = streams[1].data(client.frw)
+	assert.Equal(t, "23", data)
+	assert.True(t, closed)
+	replay, err := header.Metadata.Bool(ReplayKey)
+	assert.NoError(t, err)
+	assert.True(t, replay)
+	spooledAt, err := header.Metadata.Time(SpooledAtKey)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), spooledAt, time.Minute)
+
+	// the buffer is spooled if the writer stops before it is sent.
+	setAllow(false)
+	ctrl(1).lose(errors.New("timeout: no recent network activity"))
+	assert.Eventually(t, func() bool { return rc.State() == ConnStateReconnecting }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err = client.NewWriter(ctx, "sensors", nil, &WriterOption{Spool: sp})
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("4"))
+	assert.NoError(t, err)
+	cancel()
+	assert.ErrorIs(t, w.Close(), context.Canceled)
+
+	rec, ok, err := sp.Peek()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "4", string(rec.Data))
+}
+
+func TestWriterSpoolReplayLost(t *testing.T) {
+	ctrl := newFakeController("a")
+	dial := func(ctx context.Context, addr string, option *ClientOption) (ClientController, error) {
+		return ctrl, nil
+	}
+
+	option, err := NewClientOption(WithReconnect(&ReconnectOption{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}))
+	assert.NoError(t, err)
+	rc, err := openReconnectingConn(context.Background(), "localhost:9000", option, dial)
+	assert.NoError(t, err)
+	client, err := NewClient(rc, option)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	sp, err := spool.Open(t.TempDir(), spool.Option{})
+	assert.NoError(t, err)
+	defer sp.Close()
+	for _, data := range []string{"1", "2"} {
+		assert.NoError(t, sp.Append([]byte(data)))
+	}
+
+	// the replay stream is not closed successfully, so the records are not committed and replayed again.
+	ctrl.failClose(errors.New("stream reset"))
+	w, err := client.NewWriter(context.Background(), "sensors", nil, &WriterOption{Spool: sp})
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.Equal(t, 0, sp.Len())
+
+	streams := ctrl.openedStreams()
+	assert.Len(t, streams, 2)
+
+	_, data, closed := streams[0].data(client.frw)
+	assert.Equal(t, "12", data)
+	assert.False(t, closed)
+
+	header, data, closed := streams[1].data(client.frw)
+	assert.Equal(t, "12", data)
+	assert.True(t, closed)
+	replay, err := header.Metadata.Bool(ReplayKey)
+	assert.NoError(t, err)
+	assert.True(t, replay)
+}
+
+func TestWriterSpoolDelivery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := listenServer(t, ctx, nil)
+	writer := openTestClient(t, ctx, addr)
+
+	sp, err := spool.Open(t.TempDir(), spool.Option{})
+	assert.NoError(t, err)
+	defer sp.Close()
+	for _, data := range []string{"1", "2"} {
+		assert.NoError(t, sp.Append([]byte(data)))
+	}
+
+	// the tag is not observed, so the records replayed are not committed before the writer stops.
+	wctx, wcancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer wcancel()
+	w, err := writer.NewWriter(wctx, "sensors", nil, &WriterOption{Spool: sp})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, w.Close(), context.DeadlineExceeded)
+	assert.Equal(t, 2, sp.Len())
+
+	// the records are committed after they are delivered.
+	streams, _ := observe(openTestClient(t, ctx, addr), "sensors")
+	w, err = writer.NewWriter(ctx, "sensors", nil, &WriterOption{Spool: sp})
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.Equal(t, 0, sp.Len())
+
+	// the stream parked for the first writer is delivered too, the records are received twice but never lost.
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, "12", string(receive(t, streams).data))
+	}
+}
+
+func TestWriterPersist(t *testing.T) {
+	option, err := NewClientOption()
+	assert.NoError(t, err)
+	client := &Client{option: option}
+
+	// a record of 1 byte takes 17 bytes with its header, so the spool holds 3 of them.
+	sp, err := spool.Open(t.TempDir(), spool.Option{MaxSize: 3 * 17})
+	assert.NoError(t, err)
+	defer sp.Close()
+	assert.NoError(t, sp.Append([]byte("1")))
+
+	// the buffer is spooled after the records in the spool rather than dropped.
+	w := &Writer{client: client, spool: sp, chunks: [][]byte{[]byte("2")}, size: 1, err: context.Canceled}
+	w.persist()
+	assert.Empty(t, w.chunks)
+	assert.Equal(t, 0, w.size)
+	assert.Equal(t, 2, sp.Len())
+
+	// the buffer that cannot be spooled is kept and reported.
+	w = &Writer{client: client, spool: sp, chunks: [][]byte{[]byte("3"), []byte("4")}, size: 2, err: context.Canceled}
+	w.persist()
+	assert.Equal(t, [][]byte{[]byte("4")}, w.chunks)
+	assert.Equal(t, 1, w.size)
+	assert.ErrorIs(t, w.err, context.Canceled)
+	assert.ErrorIs(t, w.err, spool.ErrFull)
+}